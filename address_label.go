@@ -0,0 +1,36 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import "os"
+
+const addressLabelKey = "KITEX_ETCD_RESOLVER_ADDRESS_LABEL"
+
+func getAddressLabel(label string) string {
+	if label != "" {
+		return label
+	}
+	return os.Getenv(addressLabelKey)
+}
+
+// chooseAddresses replaces the primary addresses with the advertised addresses of the label if there are.
+func (e *etcdResolver) chooseAddresses(infos []instanceInfo) []instanceInfo {
+	for i := range infos {
+		if addr, ok := infos[i].Addresses[e.addressLabel]; ok && addr != "" {
+			infos[i].Address = addr
+		}
+	}
+	return infos
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	aliasPrefixSuffix = "-aliases"
+	maxAliasDepth     = 8
+	// aliasWeightScale is the total weight of the instances of a target with weight 1
+	aliasWeightScale = 100
+)
+
+// ServiceAlias maps a service name to one or more services.
+// It is stored as JSON under <prefix>-aliases/<service>.
+type ServiceAlias struct {
+	Targets []AliasTarget `json:"targets"`
+}
+
+// AliasTarget is a service an alias resolves to, traffic is split between targets by weight.
+type AliasTarget struct {
+	Service string `json:"service"`
+	Weight  int    `json:"weight"`
+}
+
+func aliasKey(prefix, service string) string {
+	return prefix + aliasPrefixSuffix + "/" + service
+}
+
+// PutServiceAlias stores the alias of the service.
+func PutServiceAlias(ctx context.Context, client *clientv3.Client, prefix, service string, alias *ServiceAlias) error {
+	val, err := json.Marshal(alias)
+	if err != nil {
+		return err
+	}
+	_, err = client.Put(ctx, aliasKey(prefix, service), string(val))
+	return err
+}
+
+// DeleteServiceAlias deletes the alias of the service.
+func DeleteServiceAlias(ctx context.Context, client *clientv3.Client, prefix, service string) error {
+	_, err := client.Delete(ctx, aliasKey(prefix, service))
+	return err
+}
+
+// resolveAlias resolves the service following its alias if there is one.
+// The instances of each target are weighted by the weight of the target,
+// and the numbers of instances registered for the targets are summed up.
+func (e *etcdResolver) resolveAlias(ctx context.Context, desc string, path []string) ([]instanceInfo, int, error) {
+	for _, p := range path {
+		if p == desc {
+			return nil, 0, fmt.Errorf("alias loop: %s -> %s", strings.Join(path, " -> "), desc)
+		}
+	}
+	if len(path) >= maxAliasDepth {
+		return nil, 0, fmt.Errorf("alias too deep: %s -> %s", strings.Join(path, " -> "), desc)
+	}
+	value, err := e.readAlias(ctx, desc)
+	if err != nil {
+		return nil, 0, err
+	}
+	if value == nil {
+		return e.resolveInstances(ctx, desc)
+	}
+	var alias ServiceAlias
+	if err = json.Unmarshal(value, &alias); err != nil {
+		return nil, 0, fmt.Errorf("fail to unmarshal alias of %s: %w", desc, err)
+	}
+
+	path = append(path[:len(path):len(path)], desc)
+	var infos []instanceInfo
+	var registered int
+	index := make(map[string]int)
+	for _, target := range alias.Targets {
+		if target.Weight <= 0 {
+			continue
+		}
+		targetInfos, targetRegistered, err := e.resolveAlias(ctx, target.Service, path)
+		if err != nil {
+			return nil, 0, err
+		}
+		registered += targetRegistered
+		var total int
+		for _, info := range targetInfos {
+			total += info.Weight
+		}
+		for _, info := range targetInfos {
+			if total > 0 {
+				info.Weight = info.Weight * target.Weight * aliasWeightScale / total
+				if info.Weight <= 0 {
+					info.Weight = 1
+				}
+			}
+			// the same instance of different targets takes the traffic of both
+			if i, ok := index[info.Address]; ok {
+				infos[i].Weight += info.Weight
+				continue
+			}
+			index[info.Address] = len(infos)
+			infos = append(infos, info)
+		}
+	}
+	return infos, registered, nil
+}
+
+// readAlias reads the alias of the service, or nil if it has none.
+func (e *etcdResolver) readAlias(ctx context.Context, desc string) ([]byte, error) {
+	kvs, _, err := e.readShared(ctx, aliasKey(e.prefix, desc), false)
+	if err != nil || len(kvs) == 0 {
+		return nil, err
+	}
+	return kvs[0].Value, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// clientPool shares etcd clients between the resolvers and registries created with the same settings.
+// A client is closed when the last user of it releases it.
+var clientPool = struct {
+	sync.Mutex
+	byKey    map[string][]*pooledClient
+	byClient map[*clientv3.Client]*pooledClient
+}{
+	byKey:    make(map[string][]*pooledClient),
+	byClient: make(map[*clientv3.Client]*pooledClient),
+}
+
+type pooledClient struct {
+	key    string
+	config clientv3.Config
+	client *clientv3.Client
+	refs   int
+}
+
+// acquireClient returns a shared etcd client for the config, and creates one if there is none.
+// Configs with settings which can not be compared, like dial options, loggers or tls callbacks, are not shared.
+func acquireClient(cfg clientv3.Config) (*clientv3.Client, error) {
+	if !shareable(&cfg) {
+		return clientv3.New(cfg)
+	}
+	key := clientKey(&cfg)
+
+	clientPool.Lock()
+	defer clientPool.Unlock()
+	for _, pc := range clientPool.byKey[key] {
+		if sameRootCAs(pc.config.TLS, cfg.TLS) {
+			pc.refs++
+			return pc.client, nil
+		}
+	}
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pc := &pooledClient{
+		key:    key,
+		config: cfg,
+		client: client,
+		refs:   1,
+	}
+	clientPool.byKey[key] = append(clientPool.byKey[key], pc)
+	clientPool.byClient[client] = pc
+	return client, nil
+}
+
+// releaseClient releases a client returned by acquireClient, and closes it if it has no users anymore.
+func releaseClient(client *clientv3.Client) error {
+	clientPool.Lock()
+	pc, ok := clientPool.byClient[client]
+	if !ok {
+		clientPool.Unlock()
+		return client.Close()
+	}
+	pc.refs--
+	if pc.refs > 0 {
+		clientPool.Unlock()
+		return nil
+	}
+	delete(clientPool.byClient, client)
+	pcs := clientPool.byKey[pc.key]
+	for i := range pcs {
+		if pcs[i] == pc {
+			pcs = append(pcs[:i], pcs[i+1:]...)
+			break
+		}
+	}
+	if len(pcs) == 0 {
+		delete(clientPool.byKey, pc.key)
+	} else {
+		clientPool.byKey[pc.key] = pcs
+	}
+	clientPool.Unlock()
+	return client.Close()
+}
+
+func shareable(cfg *clientv3.Config) bool {
+	return cfg.Context == nil && cfg.Logger == nil && cfg.LogConfig == nil && len(cfg.DialOptions) == 0 &&
+		tlsShareable(cfg.TLS)
+}
+
+// tlsShareable reports whether the tls config has no callbacks or caches, which can not be compared.
+func tlsShareable(cfg *tls.Config) bool {
+	if cfg == nil {
+		return true
+	}
+	return cfg.Rand == nil && cfg.Time == nil && cfg.GetCertificate == nil && cfg.GetClientCertificate == nil &&
+		cfg.GetConfigForClient == nil && cfg.VerifyPeerCertificate == nil && cfg.VerifyConnection == nil &&
+		cfg.ClientSessionCache == nil && cfg.UnwrapSession == nil && cfg.WrapSession == nil && cfg.KeyLogWriter == nil
+}
+
+// clientKey returns the normalized key of the config, the root CAs of tls are compared separately.
+func clientKey(cfg *clientv3.Config) string {
+	endpoints := make([]string, 0, len(cfg.Endpoints))
+	seen := make(map[string]bool, len(cfg.Endpoints))
+	for _, ep := range cfg.Endpoints {
+		ep = strings.TrimSuffix(strings.TrimSpace(ep), "/")
+		if !seen[ep] {
+			seen[ep] = true
+			endpoints = append(endpoints, ep)
+		}
+	}
+	sort.Strings(endpoints)
+	password := sha256.Sum256([]byte(cfg.Password))
+	return fmt.Sprintf("%s|%s|%x|%s|%v|%v|%v|%v|%d|%d|%t|%t",
+		strings.Join(endpoints, ","), cfg.Username, password, tlsFingerprint(cfg.TLS),
+		cfg.DialTimeout, cfg.DialKeepAliveTime, cfg.DialKeepAliveTimeout, cfg.AutoSyncInterval,
+		cfg.MaxCallSendMsgSize, cfg.MaxCallRecvMsgSize, cfg.RejectOldCluster, cfg.PermitWithoutStream)
+}
+
+// tlsFingerprint returns the fingerprint of the client certificates and the other settings of tls except root CAs.
+func tlsFingerprint(cfg *tls.Config) string {
+	if cfg == nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, cert := range cfg.Certificates {
+		for _, der := range cert.Certificate {
+			h.Write(der)
+		}
+		h.Write([]byte{0})
+	}
+	fmt.Fprintf(h, "%s|%v|%v|%v|%v|%v|%v|%v|%v|%v|%v", cfg.ServerName, cfg.InsecureSkipVerify,
+		cfg.NextProtos, cfg.ClientAuth, cfg.CipherSuites, cfg.SessionTicketsDisabled, cfg.MinVersion, cfg.MaxVersion,
+		cfg.CurvePreferences, cfg.DynamicRecordSizingDisabled, cfg.Renegotiation)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sameRootCAs(a, b *tls.Config) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.RootCAs == nil || b.RootCAs == nil {
+		return a.RootCAs == b.RootCAs
+	}
+	return a.RootCAs.Equal(b.RootCAs)
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestClientKeyWithTLS(t *testing.T) {
+	newConfig := func(tlsConfig *tls.Config) *clientv3.Config {
+		return &clientv3.Config{Endpoints: []string{"127.0.0.1:2379"}, TLS: tlsConfig}
+	}
+	base := newConfig(&tls.Config{ServerName: "etcd"})
+	require.True(t, shareable(base))
+	require.Equal(t, clientKey(base), clientKey(newConfig(&tls.Config{ServerName: "etcd"})))
+
+	// tls settings other than certificates are part of the key
+	for _, tlsConfig := range []*tls.Config{
+		{ServerName: "etcd", MinVersion: tls.VersionTLS13},
+		{ServerName: "etcd", MaxVersion: tls.VersionTLS12},
+		{ServerName: "etcd", CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}},
+		{ServerName: "etcd", CurvePreferences: []tls.CurveID{tls.X25519}},
+		{ServerName: "etcd", NextProtos: []string{"h2"}},
+		{ServerName: "etcd", Renegotiation: tls.RenegotiateOnceAsClient},
+	} {
+		cfg := newConfig(tlsConfig)
+		require.True(t, shareable(cfg))
+		require.NotEqual(t, clientKey(base), clientKey(cfg))
+	}
+
+	// callbacks can not be compared, so the config is not shared
+	for _, tlsConfig := range []*tls.Config{
+		{GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return nil, nil }},
+		{VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error { return nil }},
+		{VerifyConnection: func(tls.ConnectionState) error { return nil }},
+		{ClientSessionCache: tls.NewLRUClientSessionCache(1)},
+	} {
+		require.False(t, shareable(newConfig(tlsConfig)))
+	}
+}
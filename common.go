@@ -14,7 +14,11 @@
 
 package etcd
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+)
 
 func serviceKeyPrefix(prefix string, serviceName string) string {
 	prefix = prefix + "/%v/"
@@ -32,4 +36,36 @@ type instanceInfo struct {
 	Address string            `json:"address"`
 	Weight  int               `json:"weight"`
 	Tags    map[string]string `json:"tags"`
+
+	// Addresses are the addresses of the instance in other networks, keyed by labels such as internal, node and external
+	Addresses map[string]string `json:"addresses,omitempty"`
+
+	// the address the instance is registered with, before it is replaced by an address label or translation
+	registeredAddress string
+}
+
+// equal reports whether the two infos describe the same instance with the same weight and tags.
+func (i *instanceInfo) equal(o *instanceInfo) bool {
+	if i.Network != o.Network || i.Address != o.Address || i.Weight != o.Weight || len(i.Tags) != len(o.Tags) {
+		return false
+	}
+	for k, v := range i.Tags {
+		if ov, ok := o.Tags[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// toInstanceInfo converts a discovery instance back to the info stored in etcd.
+func toInstanceInfo(ins discovery.Instance) instanceInfo {
+	info := instanceInfo{
+		Network: ins.Address().Network(),
+		Address: ins.Address().String(),
+		Weight:  ins.Weight(),
+	}
+	if t, ok := ins.(interface{ Tags() map[string]string }); ok {
+		info.Tags = t.Tags()
+	}
+	return info
 }
@@ -0,0 +1,137 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+)
+
+// SourceTagKey is the tag key of the source name of the instances resolved by a composite resolver.
+const SourceTagKey = "etcd_source"
+
+// CompositeMode decides how a composite resolver combines the instances of its sources.
+type CompositeMode int
+
+const (
+	// CompositeMerge merges the instances of all sources, an address is taken from the first source which has it.
+	CompositeMerge CompositeMode = iota
+	// CompositeFirstNonEmpty uses the instances of the first source which has any.
+	CompositeFirstNonEmpty
+)
+
+// CompositeSource is a source of a composite resolver, usually an etcd resolver with its own client and prefix.
+type CompositeSource struct {
+	Name     string
+	Resolver discovery.Resolver
+}
+
+// compositeResolver resolves services from an ordered list of sources.
+type compositeResolver struct {
+	mode    CompositeMode
+	sources []CompositeSource
+}
+
+// NewCompositeResolver creates a resolver which queries the sources in order and combines their instances by mode.
+func NewCompositeResolver(mode CompositeMode, sources ...CompositeSource) (discovery.Resolver, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("missing sources of composite resolver")
+	}
+	if mode != CompositeMerge && mode != CompositeFirstNonEmpty {
+		return nil, fmt.Errorf("invalid composite mode %d", mode)
+	}
+	return &compositeResolver{
+		mode:    mode,
+		sources: sources,
+	}, nil
+}
+
+// Target implements the Resolver interface.
+func (c *compositeResolver) Target(ctx context.Context, target rpcinfo.EndpointInfo) (description string) {
+	return target.ServiceName()
+}
+
+// Resolve implements the Resolver interface.
+// A source failing to resolve is skipped, and the error is returned only if no source has any instance.
+func (c *compositeResolver) Resolve(ctx context.Context, desc string) (discovery.Result, error) {
+	var eps []discovery.Instance
+	var lastErr error
+	seen := make(map[string]struct{})
+	for _, source := range c.sources {
+		res, err := source.Resolver.Resolve(ctx, desc)
+		if err != nil {
+			klog.Debugf("composite resolver source %s resolve %s failed with err: %v", source.Name, desc, err)
+			lastErr = err
+			continue
+		}
+		for _, ins := range res.Instances {
+			addr := ins.Address().String()
+			if _, ok := seen[addr]; ok {
+				continue
+			}
+			seen[addr] = struct{}{}
+			info := toInstanceInfo(ins)
+			tags := make(map[string]string, len(info.Tags)+1)
+			for k, v := range info.Tags {
+				tags[k] = v
+			}
+			tags[SourceTagKey] = source.Name
+			eps = append(eps, discovery.NewInstance(info.Network, info.Address, info.Weight, tags))
+		}
+		if c.mode == CompositeFirstNonEmpty && len(eps) > 0 {
+			break
+		}
+	}
+	if len(eps) == 0 {
+		if lastErr != nil {
+			return discovery.Result{}, lastErr
+		}
+		return discovery.Result{}, fmt.Errorf("no instance remains for %v", desc)
+	}
+	return discovery.Result{
+		Cacheable: true,
+		CacheKey:  desc,
+		Instances: eps,
+	}, nil
+}
+
+// Diff implements the Resolver interface.
+func (c *compositeResolver) Diff(cacheKey string, prev, next discovery.Result) (discovery.Change, bool) {
+	return diff(cacheKey, prev, next)
+}
+
+// Name implements the Resolver interface.
+func (c *compositeResolver) Name() string {
+	return "etcd-composite"
+}
+
+// Close closes all sources which can be closed.
+func (c *compositeResolver) Close() error {
+	var errs []error
+	for _, source := range c.sources {
+		if closer, ok := source.Resolver.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
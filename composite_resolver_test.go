@@ -0,0 +1,75 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompositeResolver(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	primary, err := NewEtcdResolver([]string{endpoint})
+	require.Nil(t, err)
+	secondary, err := NewEtcdResolver([]string{endpoint}, WithEtcdServicePrefix("kitex/registry-etcd-dr"))
+	require.Nil(t, err)
+	cli := primary.(*etcdResolver).etcdClient
+
+	put := func(prefix, addr string) {
+		_, err := cli.Put(context.TODO(), serviceKey(prefix, serviceName, addr), `{"network":"tcp","address":"`+addr+`","weight":10}`)
+		require.Nil(t, err)
+	}
+	put("kitex/registry-etcd", "127.0.0.1:8000")
+	put("kitex/registry-etcd-dr", "127.0.0.1:8000")
+	put("kitex/registry-etcd-dr", "127.0.0.1:8001")
+
+	sources := []CompositeSource{{Name: "primary", Resolver: primary}, {Name: "dr", Resolver: secondary}}
+	_, err = NewCompositeResolver(CompositeMerge)
+	require.NotNil(t, err)
+
+	merged, err := NewCompositeResolver(CompositeMerge, sources...)
+	require.Nil(t, err)
+	result, err := merged.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:8000", 10, map[string]string{SourceTagKey: "primary"}),
+		discovery.NewInstance("tcp", "127.0.0.1:8001", 10, map[string]string{SourceTagKey: "dr"}),
+	}, result.Instances)
+
+	first, err := NewCompositeResolver(CompositeFirstNonEmpty, sources...)
+	require.Nil(t, err)
+	result, err = first.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:8000", 10, map[string]string{SourceTagKey: "primary"}),
+	}, result.Instances)
+
+	// the primary source is empty
+	_, err = cli.Delete(context.TODO(), serviceKey("kitex/registry-etcd", serviceName, "127.0.0.1:8000"))
+	require.Nil(t, err)
+	result, err = first.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(result.Instances))
+	source, _ := result.Instances[0].Tag(SourceTagKey)
+	require.Equal(t, "dr", source)
+
+	require.Nil(t, merged.(io.Closer).Close())
+	teardownEmbedEtcd(s)
+}
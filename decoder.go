@@ -0,0 +1,123 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+)
+
+// Decoder decodes the value of a key under the service prefix into an instance.
+// It returns an error if the value is not in its format, so that the next decoder is tried.
+type Decoder func(key string, value []byte) (discovery.Instance, error)
+
+var errMissingAddress = errors.New("missing address")
+
+// decodeJSON decodes the JSON written by etcdRegistry.
+func decodeJSON(value []byte) (instanceInfo, error) {
+	var info instanceInfo
+	if err := json.Unmarshal(value, &info); err != nil {
+		return info, err
+	}
+	if info.Address == "" {
+		return info, errMissingAddress
+	}
+	return info, nil
+}
+
+// decodeAddress decodes a plain "host:port" value.
+func decodeAddress(value []byte) (instanceInfo, error) {
+	addr := string(bytes.TrimSpace(value))
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return instanceInfo{}, err
+	}
+	return instanceInfo{Network: "tcp", Address: addr}, nil
+}
+
+// decoderChain tries the built-in formats and then the user decoders in order.
+type decoderChain struct {
+	decoders []Decoder
+
+	mu sync.Mutex
+	// the number of decode errors of each key which still fails to decode
+	errors map[string]uint64
+}
+
+func newDecoderChain(decoders []Decoder) *decoderChain {
+	return &decoderChain{
+		decoders: decoders,
+		errors:   make(map[string]uint64),
+	}
+}
+
+func (d *decoderChain) decode(key string, value []byte) (instanceInfo, error) {
+	info, err := decodeJSON(value)
+	if err == nil {
+		return info, nil
+	}
+	errs := []error{fmt.Errorf("json: %w", err)}
+	if info, err = decodeAddress(value); err == nil {
+		return info, nil
+	}
+	errs = append(errs, fmt.Errorf("address: %w", err))
+	for _, decoder := range d.decoders {
+		ins, err := decoder(key, value)
+		if err == nil && ins != nil {
+			return toInstanceInfo(ins), nil
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	d.mu.Lock()
+	d.errors[key]++
+	d.mu.Unlock()
+	return instanceInfo{}, errors.Join(errs...)
+}
+
+// retain drops the decode errors of the keys with the prefix except the failed ones,
+// so that the keys which have been deleted or fixed are not kept forever.
+func (d *decoderChain) retain(prefix string, failed map[string]struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.errors {
+		if _, ok := failed[key]; !ok && strings.HasPrefix(key, prefix) {
+			delete(d.errors, key)
+		}
+	}
+}
+
+// GetDecodeErrors returns the number of times the value of each key failed to decode.
+func GetDecodeErrors(r discovery.Resolver) map[string]uint64 {
+	er, ok := r.(*etcdResolver)
+	if !ok {
+		panic("invalid resolver type: not etcdResolver")
+	}
+	d := er.decoder
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make(map[string]uint64, len(d.errors))
+	for k, v := range d.errors {
+		res[k] = v
+	}
+	return res
+}
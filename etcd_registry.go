@@ -23,6 +23,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudwego/kitex/pkg/klog"
@@ -36,6 +37,12 @@ const (
 	defaultTTL          = 60
 	kitexIpToRegistry   = "KITEX_IP_TO_REGISTRY"
 	kitexPortToRegistry = "KITEX_PORT_TO_REGISTRY"
+	// kitexWeightToRegistry replaces the weight of registry info
+	kitexWeightToRegistry = "KITEX_WEIGHT_TO_REGISTRY"
+	// kitexTagsToRegistry is a list like "zone=az1,version=v2" merged over the tags of registry info
+	kitexTagsToRegistry = "KITEX_TAGS_TO_REGISTRY"
+	// minLeaseTTL is the minimum TTL of etcd with the default election timeout, shorter TTLs are raised to it by etcd
+	minLeaseTTL = 2
 )
 
 type etcdRegistry struct {
@@ -46,6 +53,21 @@ type etcdRegistry struct {
 	stop        chan struct{}
 	address     net.Addr
 	prefix      string
+	closeOnce   sync.Once
+
+	statusMu sync.Mutex
+	status   ClusterStatus
+
+	// labeled addresses published with the primary address
+	addresses map[string]string
+
+	// lease TTLs of services overriding leaseTTL
+	serviceLeaseTTLs map[string]int64
+
+	// mu guards meta and re-registrations against Close
+	mu sync.Mutex
+	// done is closed by Close to stop all keepRegister loops
+	done chan struct{}
 }
 
 type registerMeta struct {
@@ -65,17 +87,24 @@ func NewEtcdRegistry(endpoints []string, opts ...Option) (registry.Registry, err
 	for _, opt := range opts {
 		opt(cfg)
 	}
-	etcdClient, err := clientv3.New(*cfg.EtcdConfig)
+	leaseTTL, err := getLeaseTTL(cfg)
+	if err != nil {
+		return nil, err
+	}
+	etcdClient, err := acquireClient(*cfg.EtcdConfig)
 	if err != nil {
 		return nil, err
 	}
 	retryConfig := retry.NewRetryConfig()
 	return &etcdRegistry{
-		etcdClient:  etcdClient,
-		leaseTTL:    getTTL(),
-		retryConfig: retryConfig,
-		stop:        make(chan struct{}, 1),
-		prefix:      cfg.Prefix,
+		etcdClient:       etcdClient,
+		leaseTTL:         leaseTTL,
+		retryConfig:      retryConfig,
+		stop:             make(chan struct{}, 1),
+		done:             make(chan struct{}),
+		prefix:           cfg.Prefix,
+		addresses:        cfg.AdvertisedAddresses,
+		serviceLeaseTTLs: cfg.ServiceLeaseTTLs,
 	}, nil
 }
 
@@ -100,23 +129,30 @@ func NewEtcdRegistryWithRetry(endpoints []string, retryConfig *retry.Config, opt
 	for _, opt := range opts {
 		opt(cfg)
 	}
-	etcdClient, err := clientv3.New(*cfg.EtcdConfig)
+	leaseTTL, err := getLeaseTTL(cfg)
+	if err != nil {
+		return nil, err
+	}
+	etcdClient, err := acquireClient(*cfg.EtcdConfig)
 	if err != nil {
 		return nil, err
 	}
 	return &etcdRegistry{
-		etcdClient:  etcdClient,
-		leaseTTL:    getTTL(),
-		retryConfig: retryConfig,
-		stop:        make(chan struct{}, 1),
-		prefix:      cfg.Prefix,
+		etcdClient:       etcdClient,
+		leaseTTL:         leaseTTL,
+		retryConfig:      retryConfig,
+		stop:             make(chan struct{}, 1),
+		done:             make(chan struct{}),
+		prefix:           cfg.Prefix,
+		addresses:        cfg.AdvertisedAddresses,
+		serviceLeaseTTLs: cfg.ServiceLeaseTTLs,
 	}, nil
 }
 
 // NewEtcdRegistryWithAuth creates an etcd based registry with given username and password.
 // Deprecated: Use WithAuthOpt instead.
 func NewEtcdRegistryWithAuth(endpoints []string, username, password string) (registry.Registry, error) {
-	etcdClient, err := clientv3.New(clientv3.Config{
+	etcdClient, err := acquireClient(clientv3.Config{
 		Endpoints: endpoints,
 		Username:  username,
 		Password:  password,
@@ -130,6 +166,7 @@ func NewEtcdRegistryWithAuth(endpoints []string, username, password string) (reg
 		leaseTTL:    getTTL(),
 		retryConfig: retryConfig,
 		stop:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
 	}, nil
 }
 
@@ -138,12 +175,14 @@ func (e *etcdRegistry) Register(info *registry.Info) error {
 	if err := validateRegistryInfo(info); err != nil {
 		return err
 	}
-	leaseID, err := e.grantLease()
+	leaseID, err := e.grantLease(e.leaseTTLOf(info.ServiceName))
 	if err != nil {
+		e.setStatus(false, err)
 		return err
 	}
 
 	if err := e.register(info, leaseID); err != nil {
+		e.setStatus(false, err)
 		return err
 	}
 	meta := registerMeta{
@@ -151,9 +190,13 @@ func (e *etcdRegistry) Register(info *registry.Info) error {
 	}
 	meta.ctx, meta.cancel = context.WithCancel(context.Background())
 	if err := e.keepalive(&meta); err != nil {
+		e.setStatus(false, err)
 		return err
 	}
+	e.mu.Lock()
 	e.meta = &meta
+	e.mu.Unlock()
+	e.setStatus(true, nil)
 	return nil
 }
 
@@ -165,10 +208,32 @@ func (e *etcdRegistry) Deregister(info *registry.Info) error {
 	if err := e.deregister(info); err != nil {
 		return err
 	}
-	e.meta.cancel()
+	e.mu.Lock()
+	if e.meta != nil {
+		e.meta.cancel()
+	}
+	e.mu.Unlock()
+	e.setStatus(false, nil)
 	return nil
 }
 
+// Close stops keeping the instances registered and releases the etcd client of the registry,
+// the client is closed when no registry or resolver uses it.
+func (e *etcdRegistry) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		// wait for an in-flight re-registration, so that no loop registers again after the client is released
+		e.mu.Lock()
+		close(e.done)
+		if e.meta != nil {
+			e.meta.cancel()
+		}
+		e.mu.Unlock()
+		err = releaseClient(e.etcdClient)
+	})
+	return err
+}
+
 func (e *etcdRegistry) register(info *registry.Info, leaseID clientv3.LeaseID) error {
 	addr, err := e.getAddressOfRegistration(info)
 	if err != nil {
@@ -180,10 +245,11 @@ func (e *etcdRegistry) register(info *registry.Info, leaseID clientv3.LeaseID) e
 		addr = e.address.String()
 	}
 	val, err := json.Marshal(&instanceInfo{
-		Network: network,
-		Address: addr,
-		Weight:  info.Weight,
-		Tags:    info.Tags,
+		Network:   network,
+		Address:   addr,
+		Weight:    getWeightOfRegistration(info),
+		Tags:      getTagsOfRegistration(info),
+		Addresses: e.addresses,
 	})
 	if err != nil {
 		return err
@@ -195,26 +261,31 @@ func (e *etcdRegistry) register(info *registry.Info, leaseID clientv3.LeaseID) e
 		return err
 	}
 
-	go func(key, val string) {
-		e.keepRegister(key, val, e.retryConfig)
-	}(serviceKey(e.prefix, info.ServiceName, addr), string(val))
+	go func(key, val string, ttl int64) {
+		e.keepRegister(key, val, ttl, e.retryConfig)
+	}(serviceKey(e.prefix, info.ServiceName, addr), string(val), e.leaseTTLOf(info.ServiceName))
 
 	return nil
 }
 
 // keepRegister keep service registered status
-// maxRetry == 0 means retry forever
-func (e *etcdRegistry) keepRegister(key, val string, retryConfig *retry.Config) {
+// the backoff of retryConfig decides the retry delays and when to give up
+func (e *etcdRegistry) keepRegister(key, val string, ttl int64, retryConfig *retry.Config) {
 	var failedTimes uint
+	backoff := retryConfig.NewBackoff()
 	delay := retryConfig.ObserveDelay
-	for retryConfig.MaxAttemptTimes == 0 || failedTimes < retryConfig.MaxAttemptTimes {
+	ok := true
+	for ok {
 		select {
-		case _, ok := <-e.stop:
-			if !ok {
+		case _, open := <-e.stop:
+			if !open {
 				close(e.stop)
 			}
 			klog.Infof("stop keep register service %s", key)
 			return
+		case <-e.done:
+			klog.Infof("stop keep register service %s", key)
+			return
 		case <-time.After(delay):
 		}
 
@@ -223,45 +294,73 @@ func (e *etcdRegistry) keepRegister(key, val string, retryConfig *retry.Config)
 		resp, err := e.etcdClient.Get(ctx, key)
 		if err != nil {
 			klog.Warnf("keep register get %s failed with err: %v", key, err)
-			delay = retryConfig.RetryDelay
+			e.setError(err)
 			failedTimes++
+			delay, ok = backoff.Next()
 			continue
 		}
 
 		if len(resp.Kvs) == 0 {
-			klog.Infof("keep register service %s", key)
-			delay = retryConfig.RetryDelay
-			leaseID, err := e.grantLease()
-			if err != nil {
-				klog.Warnf("keep register grant lease %s failed with err: %v", key, err)
-				failedTimes++
-				continue
-			}
-
-			_, err = e.etcdClient.Put(ctx, key, val, clientv3.WithLease(leaseID))
-			if err != nil {
-				klog.Warnf("keep register put %s failed with err: %v", key, err)
+			if !e.reregister(ctx, key, val, ttl) {
+				select {
+				case <-e.done:
+					klog.Infof("stop keep register service %s", key)
+					return
+				default:
+				}
 				failedTimes++
+				delay, ok = backoff.Next()
 				continue
 			}
-
-			meta := registerMeta{
-				leaseID: leaseID,
-			}
-			meta.ctx, meta.cancel = context.WithCancel(context.Background())
-			if err := e.keepalive(&meta); err != nil {
-				klog.Warnf("keep register keepalive %s failed with err: %v", key, err)
-				failedTimes++
-				continue
-			}
-			e.meta.cancel()
-			e.meta = &meta
-			delay = retryConfig.ObserveDelay
 		}
 
+		e.setStatus(true, nil)
 		failedTimes = 0
+		backoff.Reset()
+		delay = retryConfig.ObserveDelay
 	}
 	klog.Errorf("keep register service %s failed times:%d", key, failedTimes)
+	e.setStatus(false, fmt.Errorf("keep register service %s failed times:%d", key, failedTimes))
+}
+
+// reregister registers the lost key again with a new lease, and returns false if it fails or the registry is closed.
+func (e *etcdRegistry) reregister(ctx context.Context, key, val string, ttl int64) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-e.done:
+		return false
+	default:
+	}
+	klog.Infof("keep register service %s", key)
+	leaseID, err := e.grantLease(ttl)
+	if err != nil {
+		klog.Warnf("keep register grant lease %s failed with err: %v", key, err)
+		e.setStatus(false, err)
+		return false
+	}
+
+	_, err = e.etcdClient.Put(ctx, key, val, clientv3.WithLease(leaseID))
+	if err != nil {
+		klog.Warnf("keep register put %s failed with err: %v", key, err)
+		e.setStatus(false, err)
+		return false
+	}
+
+	meta := registerMeta{
+		leaseID: leaseID,
+	}
+	meta.ctx, meta.cancel = context.WithCancel(context.Background())
+	if err := e.keepalive(&meta); err != nil {
+		klog.Warnf("keep register keepalive %s failed with err: %v", key, err)
+		e.setStatus(false, err)
+		return false
+	}
+	if e.meta != nil {
+		e.meta.cancel()
+	}
+	e.meta = &meta
+	return true
 }
 
 func (e *etcdRegistry) deregister(info *registry.Info) error {
@@ -279,10 +378,10 @@ func (e *etcdRegistry) deregister(info *registry.Info) error {
 	return nil
 }
 
-func (e *etcdRegistry) grantLease() (clientv3.LeaseID, error) {
+func (e *etcdRegistry) grantLease(ttl int64) (clientv3.LeaseID, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
-	resp, err := e.etcdClient.Grant(ctx, e.leaseTTL)
+	resp, err := e.etcdClient.Grant(ctx, ttl)
 	if err != nil {
 		return clientv3.NoLease, err
 	}
@@ -343,6 +442,46 @@ func (e *etcdRegistry) getAddressOfRegistration(info *registry.Info) (string, er
 	return fmt.Sprintf("%s:%d", host, p), nil
 }
 
+// getWeightOfRegistration returns the weight of the service registration.
+func getWeightOfRegistration(info *registry.Info) int {
+	// if env KITEX_WEIGHT_TO_REGISTRY is set to a positive integer, use it as weight
+	if str, exists := os.LookupEnv(kitexWeightToRegistry); exists && str != "" {
+		weight, err := strconv.Atoi(str)
+		if err == nil && weight > 0 {
+			return weight
+		}
+		klog.Warnf("invalid %s: %s, use weight of registry info", kitexWeightToRegistry, str)
+	}
+	return info.Weight
+}
+
+// getTagsOfRegistration returns the tags of the service registration.
+// The tags of env KITEX_TAGS_TO_REGISTRY take precedence over the tags of registry info.
+func getTagsOfRegistration(info *registry.Info) map[string]string {
+	str, exists := os.LookupEnv(kitexTagsToRegistry)
+	if !exists || str == "" {
+		return info.Tags
+	}
+	tags := make(map[string]string, len(info.Tags))
+	for k, v := range info.Tags {
+		tags[k] = v
+	}
+	for _, entry := range strings.Split(str, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		k, v, found := strings.Cut(entry, "=")
+		k = strings.TrimSpace(k)
+		if !found || k == "" {
+			klog.Warnf("invalid %s entry: %s", kitexTagsToRegistry, entry)
+			continue
+		}
+		tags[k] = strings.TrimSpace(v)
+	}
+	return tags
+}
+
 func validateRegistryInfo(info *registry.Info) error {
 	if info.ServiceName == "" {
 		return fmt.Errorf("missing service name in Register")
@@ -366,6 +505,36 @@ func getTTL() int64 {
 	return ttl
 }
 
+// getLeaseTTL returns the lease TTL of the registry, WithLeaseTTL takes precedence over the env.
+// It validates the TTLs set by options against the minimum TTL of etcd,
+// a TTL set by the env is used as before and only logged.
+func getLeaseTTL(cfg *Config) (int64, error) {
+	if cfg.LeaseTTL < 0 || (cfg.LeaseTTL > 0 && cfg.LeaseTTL < minLeaseTTL) {
+		return 0, fmt.Errorf("lease ttl %d is less than the minimum ttl %d of etcd", cfg.LeaseTTL, minLeaseTTL)
+	}
+	ttl := cfg.LeaseTTL
+	if ttl == 0 {
+		ttl = getTTL()
+		if ttl < minLeaseTTL {
+			klog.Warnf("lease ttl %d of env %s is less than the minimum ttl %d of etcd, which is raised by etcd", ttl, ttlKey, minLeaseTTL)
+		}
+	}
+	for service, serviceTTL := range cfg.ServiceLeaseTTLs {
+		if serviceTTL < minLeaseTTL {
+			return 0, fmt.Errorf("lease ttl %d of service %s is less than the minimum ttl %d of etcd", serviceTTL, service, minLeaseTTL)
+		}
+	}
+	return ttl, nil
+}
+
+// leaseTTLOf returns the lease TTL of the service.
+func (e *etcdRegistry) leaseTTLOf(service string) int64 {
+	if ttl, ok := e.serviceLeaseTTLs[service]; ok {
+		return ttl
+	}
+	return e.leaseTTL
+}
+
 func getLocalIPv4Host() (string, error) {
 	addr, err := net.InterfaceAddrs()
 	if err != nil {
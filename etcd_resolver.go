@@ -17,17 +17,27 @@ package etcd
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/event"
 	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	defaultWeight = 10
+	defaultWeight     = 10
+	maxPagingAttempts = 3
+	// sharedReadTimeout is the timeout of a read shared by concurrent resolves, if the first one has no deadline
+	sharedReadTimeout = 3 * time.Second
 )
 
 // etcdResolver is a resolver using etcd.
@@ -35,6 +45,53 @@ type etcdResolver struct {
 	etcdClient    *clientv3.Client
 	prefix        string
 	defaultWeight int
+	pageSize      int64
+	overrides     bool
+	aliases       bool
+	zoneFilter    *zoneFilter
+	prober        *prober
+	outlier       *outlierReporter
+	protection    *protection
+	staleCache    *staleCache
+	snapshot      *snapshotStore
+	eventBus      event.Bus
+
+	serializable   bool
+	maxRevisionLag int64
+	maxRevision    atomic.Int64
+	// the etcd revision the instances of each service are read at
+	revisions sync.Map
+	// instances read by Preload and not yet used by Resolve
+	preloaded sync.Map
+
+	watchCache *watchCache
+	cancel     context.CancelFunc
+	closeOnce  sync.Once
+
+	// concurrent reads of the same service share one etcd read
+	readGroup   singleflight.Group
+	minInterval time.Duration
+	// the last read of each prefix, only kept when minInterval is set
+	lastReads sync.Map
+
+	// local addresses which services resolve to instead of etcd
+	resolveOverrides map[string][]string
+	// the services whose override has been logged
+	loggedOverrides sync.Map
+
+	decoder *decoderChain
+
+	translator *addressTranslator
+
+	// the label of the advertised addresses to use
+	addressLabel string
+}
+
+// serviceRead is the keys with a prefix read from etcd.
+type serviceRead struct {
+	kvs      []*mvccpb.KeyValue
+	revision int64
+	readAt   time.Time
 }
 
 // NewEtcdResolver creates a etcd based resolver.
@@ -49,21 +106,71 @@ func NewEtcdResolver(endpoints []string, opts ...Option) (discovery.Resolver, er
 	for _, opt := range opts {
 		opt(cfg)
 	}
-	etcdClient, err := clientv3.New(*cfg.EtcdConfig)
+	etcdClient, err := acquireClient(*cfg.EtcdConfig)
 	if err != nil {
 		return nil, err
 	}
-	return &etcdResolver{
-		etcdClient:    etcdClient,
-		prefix:        cfg.Prefix,
-		defaultWeight: cfg.DefaultWeight,
-	}, nil
+	rs, err := newEtcdResolver(etcdClient, cfg)
+	if err != nil {
+		_ = releaseClient(etcdClient)
+		return nil, err
+	}
+	return rs, nil
+}
+
+func newEtcdResolver(etcdClient *clientv3.Client, cfg *Config) (*etcdResolver, error) {
+	snapshot, err := newSnapshotStore(cfg.SnapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rs := &etcdResolver{
+		etcdClient:     etcdClient,
+		prefix:         cfg.Prefix,
+		defaultWeight:  cfg.DefaultWeight,
+		pageSize:       cfg.ResolvePageSize,
+		overrides:      cfg.InstanceOverrides,
+		aliases:        cfg.ServiceAliases,
+		zoneFilter:     newZoneFilter(cfg),
+		prober:         newProber(cfg.HealthProbe),
+		outlier:        newOutlierReporter(cfg.Prefix, cfg.OutlierReport),
+		serializable:   cfg.SerializableRead,
+		maxRevisionLag: cfg.MaxRevisionLag,
+		protection:     newProtection(cfg.ProtectionThreshold, cfg.ProtectionMaxHold),
+		staleCache:     newStaleCache(cfg.StaleFallback, cfg.MaxStaleness),
+		snapshot:       snapshot,
+		eventBus:       cfg.EventBus,
+		cancel:         cancel,
+		minInterval:    cfg.MinResolveInterval,
+		decoder:        newDecoderChain(cfg.Decoders),
+		translator:     newAddressTranslator(cfg.AddressTranslation, cfg.AddressTranslationKey),
+		addressLabel:   getAddressLabel(cfg.AddressLabel),
+	}
+	rs.resolveOverrides = newResolveOverrides(cfg.ResolveOverrides)
+	if rs.translator != nil && rs.translator.key != "" {
+		go rs.runTranslationWatch(ctx)
+	}
+	if cfg.SharedWatch {
+		rs.watchCache = newWatchCache(cfg.Prefix)
+		go rs.runSharedWatch(ctx)
+	}
+	if rs.prober != nil {
+		if rs.outlier != nil {
+			rs.prober.onUnhealthy = func(service, addr string) {
+				if err := rs.reportSuspect(ctx, service, addr); err != nil {
+					klog.Warnf("report suspect instance %s of %s failed with err: %v", addr, service, err)
+				}
+			}
+		}
+		go rs.prober.run(ctx)
+	}
+	return rs, nil
 }
 
 // NewEtcdResolverWithAuth creates a etcd based resolver with given username and password.
 // Deprecated: Use WithAuthOpt instead.
 func NewEtcdResolverWithAuth(endpoints []string, username, password string) (discovery.Resolver, error) {
-	etcdClient, err := clientv3.New(clientv3.Config{
+	etcdClient, err := acquireClient(clientv3.Config{
 		Endpoints: endpoints,
 		Username:  username,
 		Password:  password,
@@ -71,9 +178,12 @@ func NewEtcdResolverWithAuth(endpoints []string, username, password string) (dis
 	if err != nil {
 		return nil, err
 	}
-	return &etcdResolver{
-		etcdClient: etcdClient,
-	}, nil
+	rs, err := newEtcdResolver(etcdClient, &Config{})
+	if err != nil {
+		_ = releaseClient(etcdClient)
+		return nil, err
+	}
+	return rs, nil
 }
 
 // Target implements the Resolver interface.
@@ -83,24 +193,43 @@ func (e *etcdResolver) Target(ctx context.Context, target rpcinfo.EndpointInfo)
 
 // Resolve implements the Resolver interface.
 func (e *etcdResolver) Resolve(ctx context.Context, desc string) (discovery.Result, error) {
-	prefix := serviceKeyPrefix(e.prefix, desc)
-	resp, err := e.etcdClient.Get(ctx, prefix, clientv3.WithPrefix())
+	if eps, ok := e.resolveOverride(desc); ok {
+		return discovery.Result{
+			Cacheable: true,
+			CacheKey:  desc,
+			Instances: eps,
+		}, nil
+	}
+	var infos []instanceInfo
+	var registered int
+	var err error
+	if e.aliases {
+		infos, registered, err = e.resolveAlias(ctx, desc, nil)
+	} else {
+		infos, registered, err = e.resolveInstances(ctx, desc)
+	}
 	if err != nil {
+		if eps, ok := e.fallback(desc, err); ok {
+			return discovery.Result{
+				Cacheable: true,
+				CacheKey:  desc,
+				Instances: eps,
+			}, nil
+		}
 		return discovery.Result{}, err
 	}
 	var eps []discovery.Instance
-	for _, kv := range resp.Kvs {
-		var info instanceInfo
-		err = json.Unmarshal(kv.Value, &info)
-		if err != nil {
-			klog.Warnf("fail to unmarshal with err: %v, ignore key: %v", err, string(kv.Key))
-			continue
-		}
-		weight := info.Weight
-		if weight <= 0 {
-			weight = e.defaultWeight
-		}
-		eps = append(eps, discovery.NewInstance(info.Network, info.Address, weight, info.Tags))
+	for _, info := range infos {
+		eps = append(eps, discovery.NewInstance(info.Network, info.Address, info.Weight, info.Tags))
+	}
+	if e.protection != nil {
+		eps = e.protect(desc, registered, eps)
+	}
+	if e.staleCache != nil {
+		e.staleCache.update(desc, eps)
+	}
+	if e.snapshot != nil {
+		e.snapshot.save(desc, eps)
 	}
 	if len(eps) == 0 {
 		return discovery.Result{}, fmt.Errorf("no instance remains for %v", desc)
@@ -112,9 +241,258 @@ func (e *etcdResolver) Resolve(ctx context.Context, desc string) (discovery.Resu
 	}, nil
 }
 
+// fallback returns the instances served when reading etcd fails.
+func (e *etcdResolver) fallback(desc string, cause error) ([]discovery.Instance, bool) {
+	if e.staleCache != nil {
+		if eps, ok := e.serveStale(desc, cause); ok {
+			return eps, true
+		}
+	}
+	if e.snapshot != nil {
+		if eps, ok := e.snapshot.get(desc); ok {
+			klog.Warnf("etcd resolver serves instances of %s from snapshot, err: %v", desc, cause)
+			return eps, true
+		}
+	}
+	return nil, false
+}
+
+// resolveInstances reads the instances of the service from etcd.
+// It also returns the number of instances registered in etcd, before they are filtered.
+func (e *etcdResolver) resolveInstances(ctx context.Context, desc string) ([]instanceInfo, int, error) {
+	kvs, rev, ok := e.takePreloaded(desc)
+	if !ok && e.watchCache != nil {
+		kvs, rev, ok = e.watchCache.get(desc)
+	}
+	if !ok {
+		var err error
+		kvs, rev, err = e.readService(ctx, desc)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	e.revisions.Store(desc, rev)
+	var infos []instanceInfo
+	var failed map[string]struct{}
+	for _, kv := range kvs {
+		info, err := e.decoder.decode(string(kv.Key), kv.Value)
+		if err != nil {
+			klog.Warnf("fail to decode with err: %v, ignore key: %v", err, string(kv.Key))
+			if failed == nil {
+				failed = make(map[string]struct{})
+			}
+			failed[string(kv.Key)] = struct{}{}
+			continue
+		}
+		if info.Weight <= 0 {
+			info.Weight = e.defaultWeight
+		}
+		info.registeredAddress = info.Address
+		infos = append(infos, info)
+	}
+	e.decoder.retain(serviceKeyPrefix(e.prefix, desc), failed)
+	registered := len(infos)
+	if e.overrides {
+		infos = e.applyOverrides(ctx, desc, infos)
+	}
+	if e.outlier != nil {
+		infos = e.downWeight(ctx, desc, infos)
+	}
+	if e.addressLabel != "" {
+		infos = e.chooseAddresses(infos)
+	}
+	if e.translator != nil {
+		infos = e.translator.apply(infos)
+	}
+	if e.prober != nil {
+		infos = e.prober.filter(desc, infos)
+	}
+	if e.zoneFilter != nil {
+		infos = e.zoneFilter.apply(infos)
+	}
+	return infos, registered, nil
+}
+
+// readService reads the keys of the service from etcd.
+func (e *etcdResolver) readService(ctx context.Context, desc string) ([]*mvccpb.KeyValue, int64, error) {
+	return e.readShared(ctx, serviceKeyPrefix(e.prefix, desc), true)
+}
+
+// readShared reads the key, or all keys with the prefix if prefix is true, from etcd.
+// Concurrent reads of the same key are coalesced into one etcd read,
+// and reads within the minimum interval reuse the last read of the key.
+func (e *etcdResolver) readShared(ctx context.Context, key string, prefix bool) ([]*mvccpb.KeyValue, int64, error) {
+	if e.minInterval > 0 {
+		if v, ok := e.lastReads.Load(key); ok {
+			if last := v.(*serviceRead); time.Since(last.readAt) < e.minInterval {
+				return last.kvs, last.revision, nil
+			}
+		}
+	}
+	ch := e.readGroup.DoChan(key, func() (interface{}, error) {
+		rctx, cancel := sharedReadContext(ctx)
+		defer cancel()
+		kvs, rev, err := e.get(rctx, key, prefix)
+		if err != nil {
+			return nil, err
+		}
+		read := &serviceRead{kvs: kvs, revision: rev, readAt: time.Now()}
+		if e.minInterval > 0 {
+			e.lastReads.Store(key, read)
+		}
+		return read, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, 0, res.Err
+		}
+		read := res.Val.(*serviceRead)
+		return read.kvs, read.revision, nil
+	}
+}
+
+// sharedReadContext returns the context of a read shared by all callers, which is not canceled with
+// the context of the first caller but keeps its deadline, or has sharedReadTimeout if it has none.
+func sharedReadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	}
+	return context.WithTimeout(context.WithoutCancel(ctx), sharedReadTimeout)
+}
+
+// get reads the key, or all keys with the prefix if prefix is true, and returns them with the revision they are read at.
+// If serializable read is enabled and the member lags too far behind, the keys are read again linearizably.
+func (e *etcdResolver) get(ctx context.Context, key string, prefix bool) ([]*mvccpb.KeyValue, int64, error) {
+	if !e.serializable {
+		return e.read(ctx, key, prefix, false)
+	}
+	kvs, rev, err := e.read(ctx, key, prefix, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	if maxRev := e.maxRevision.Load(); rev+e.maxRevisionLag >= maxRev {
+		return kvs, rev, nil
+	}
+	klog.Debugf("serializable read of %s at revision %d lags too far behind, read again linearizably", key, rev)
+	return e.read(ctx, key, prefix, false)
+}
+
+// read reads the key, or all keys with the prefix if prefix is true.
+// If the page size is set, keys with the prefix are read page by page at the revision of the first page,
+// and a serializable read is read again linearizably if a later page is served by a member behind that revision.
+func (e *etcdResolver) read(ctx context.Context, key string, prefix, serializable bool) ([]*mvccpb.KeyValue, int64, error) {
+	var opts []clientv3.OpOption
+	if serializable {
+		opts = append(opts, clientv3.WithSerializable())
+	}
+	if !prefix || e.pageSize <= 0 {
+		if prefix {
+			opts = append(opts, clientv3.WithPrefix())
+		}
+		resp, err := e.etcdClient.Get(ctx, key, opts...)
+		if err != nil {
+			return nil, 0, err
+		}
+		e.observeRevision(resp.Header.Revision)
+		return resp.Kvs, resp.Header.Revision, nil
+	}
+	var err error
+	for i := 0; i < maxPagingAttempts; i++ {
+		var kvs []*mvccpb.KeyValue
+		var rev int64
+		kvs, rev, err = e.getPages(ctx, key, opts)
+		if err == nil {
+			e.observeRevision(rev)
+			return kvs, rev, nil
+		}
+		// a later page is served by a member behind the revision of the first page
+		if serializable && errors.Is(err, rpctypes.ErrFutureRev) {
+			klog.Debugf("serializable paged read of %s reaches a lagging member, read again linearizably", key)
+			return e.read(ctx, key, true, false)
+		}
+		// the revision of the first page has been compacted, start over
+		if !errors.Is(err, rpctypes.ErrCompacted) {
+			break
+		}
+	}
+	return nil, 0, err
+}
+
+func (e *etcdResolver) getPages(ctx context.Context, prefix string, baseOpts []clientv3.OpOption) ([]*mvccpb.KeyValue, int64, error) {
+	var kvs []*mvccpb.KeyValue
+	var rev int64
+	key, end := prefix, clientv3.GetPrefixRangeEnd(prefix)
+	for {
+		opts := append([]clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(e.pageSize)}, baseOpts...)
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+		resp, err := e.etcdClient.Get(ctx, key, opts...)
+		if err != nil {
+			return nil, 0, err
+		}
+		if rev == 0 {
+			rev = resp.Header.Revision
+		}
+		kvs = append(kvs, resp.Kvs...)
+		if !resp.More || len(resp.Kvs) == 0 {
+			return kvs, rev, nil
+		}
+		// continue right after the last key of this page
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// observeRevision records the latest revision the resolver has seen.
+func (e *etcdResolver) observeRevision(rev int64) {
+	for {
+		maxRev := e.maxRevision.Load()
+		if rev <= maxRev || e.maxRevision.CompareAndSwap(maxRev, rev) {
+			return
+		}
+	}
+}
+
 // Diff implements the Resolver interface.
 func (e *etcdResolver) Diff(cacheKey string, prev, next discovery.Result) (discovery.Change, bool) {
-	return discovery.DefaultDiff(cacheKey, prev, next)
+	return diff(cacheKey, prev, next)
+}
+
+// diff is like discovery.DefaultDiff, but an instance whose network, weight or tags changed is reported as updated.
+func diff(cacheKey string, prev, next discovery.Result) (discovery.Change, bool) {
+	ch := discovery.Change{
+		Result: discovery.Result{
+			Cacheable: next.Cacheable,
+			CacheKey:  cacheKey,
+			Instances: next.Instances,
+		},
+	}
+
+	prevMap := make(map[string]instanceInfo, len(prev.Instances))
+	for _, ins := range prev.Instances {
+		prevMap[ins.Address().String()] = toInstanceInfo(ins)
+	}
+
+	nextMap := make(map[string]struct{}, len(next.Instances))
+	for _, ins := range next.Instances {
+		info := toInstanceInfo(ins)
+		nextMap[info.Address] = struct{}{}
+		if prevInfo, found := prevMap[info.Address]; !found {
+			ch.Added = append(ch.Added, ins)
+		} else if !prevInfo.equal(&info) {
+			ch.Updated = append(ch.Updated, ins)
+		}
+	}
+
+	for _, ins := range prev.Instances {
+		if _, found := nextMap[ins.Address().String()]; !found {
+			ch.Removed = append(ch.Removed, ins)
+		}
+	}
+	return ch, len(ch.Added)+len(ch.Updated)+len(ch.Removed) != 0
 }
 
 // Name implements the Resolver interface.
@@ -122,6 +500,17 @@ func (e *etcdResolver) Name() string {
 	return "etcd"
 }
 
+// Close stops the background goroutines of the resolver and releases its etcd client,
+// the client is closed when no registry or resolver uses it.
+func (e *etcdResolver) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		e.cancel()
+		err = releaseClient(e.etcdClient)
+	})
+	return err
+}
+
 func (e *etcdResolver) GetPrefix() string {
 	return e.prefix
 }
@@ -129,3 +518,16 @@ func (e *etcdResolver) GetPrefix() string {
 func (e *etcdResolver) GetDefaultWeight() int {
 	return e.defaultWeight
 }
+
+// GetResultRevision returns the etcd revision at which the resolver last read the instances of the service.
+func GetResultRevision(r discovery.Resolver, desc string) (int64, bool) {
+	er, ok := r.(*etcdResolver)
+	if !ok {
+		panic("invalid resolver type: not etcdResolver")
+	}
+	rev, ok := er.revisions.Load(desc)
+	if !ok {
+		return 0, false
+	}
+	return rev.(int64), true
+}
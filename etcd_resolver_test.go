@@ -21,14 +21,20 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil" //nolint
 	"math/big"
 	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -36,7 +42,10 @@ import (
 	"github.com/cloudwego/kitex/pkg/registry"
 	"github.com/cloudwego/kitex/pkg/rpcinfo"
 	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/kitex-contrib/registry-etcd/retry"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/server/v3/embed"
 )
 
@@ -44,6 +53,8 @@ const (
 	serviceName = "registry-etcd-test"
 )
 
+var embedEtcdCount int32
+
 func TestEtcdResolver(t *testing.T) {
 	s, endpoint := setupEmbedEtcd(t)
 
@@ -299,6 +310,117 @@ func TestEtcdRegistryWithEnvironmentVariable(t *testing.T) {
 	teardownEmbedEtcd(s)
 }
 
+func TestEtcdRegistryWithWeightAndTagsEnvironmentVariable(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	t.Setenv(kitexWeightToRegistry, "50")
+	t.Setenv(kitexTagsToRegistry, "zone=az1, canary=true,invalid")
+
+	rg, err := NewEtcdRegistry([]string{endpoint})
+	require.Nil(t, err)
+	rs, err := NewEtcdResolver([]string{endpoint})
+	require.Nil(t, err)
+
+	info := &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+		Tags:        map[string]string{"hello": "world", "zone": "az2"},
+	}
+	require.Nil(t, rg.Register(info))
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:8888", 50, map[string]string{"hello": "world", "zone": "az1", "canary": "true"}),
+	}, result.Instances)
+	// the tags of registry info are not modified
+	require.Equal(t, map[string]string{"hello": "world", "zone": "az2"}, info.Tags)
+	require.Nil(t, rg.Deregister(info))
+
+	// invalid weight falls back to the weight of registry info
+	t.Setenv(kitexWeightToRegistry, "-1")
+	require.Equal(t, 10, getWeightOfRegistration(info))
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdRegistryWithLeaseTTL(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	_, err := NewEtcdRegistry([]string{endpoint}, WithLeaseTTL(1))
+	require.NotNil(t, err)
+	_, err = NewEtcdRegistry([]string{endpoint}, WithServiceLeaseTTL(serviceName, 1))
+	require.NotNil(t, err)
+
+	t.Setenv(ttlKey, "30")
+	rg, err := NewEtcdRegistry([]string{endpoint}, WithLeaseTTL(20), WithServiceLeaseTTL(serviceName, 5))
+	require.Nil(t, err)
+	cli := rg.(*etcdRegistry).etcdClient
+
+	infos := map[string]int64{serviceName: 5, serviceName + "-batch": 20}
+	for service, ttl := range infos {
+		info := &registry.Info{
+			ServiceName: service,
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		}
+		require.Nil(t, rg.Register(info))
+		resp, err := cli.Get(context.TODO(), serviceKey("kitex/registry-etcd", service, "127.0.0.1:8888"))
+		require.Nil(t, err)
+		require.Equal(t, 1, len(resp.Kvs))
+		lease, err := cli.TimeToLive(context.TODO(), clientv3.LeaseID(resp.Kvs[0].Lease))
+		require.Nil(t, err)
+		require.Equal(t, ttl, lease.GrantedTTL)
+		require.Nil(t, rg.Deregister(info))
+	}
+
+	// the env is used without WithLeaseTTL
+	rg, err = NewEtcdRegistry([]string{endpoint})
+	require.Nil(t, err)
+	require.Equal(t, int64(30), rg.(*etcdRegistry).leaseTTL)
+
+	// a short TTL set by the env is not rejected
+	t.Setenv(ttlKey, "1")
+	rg, err = NewEtcdRegistry([]string{endpoint})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), rg.(*etcdRegistry).leaseTTL)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdRegistryWithRetryPolicy(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	retryConfig := retry.NewRetryConfig(
+		retry.WithObserveDelay(50*time.Millisecond),
+		retry.WithPolicy(&retry.DeadlinePolicy{
+			Policy:  &retry.ExponentialPolicy{InitialDelay: 10 * time.Millisecond, Multiplier: 2, MaxDelay: 100 * time.Millisecond},
+			Timeout: time.Minute,
+		}),
+	)
+	rg, err := NewEtcdRegistryWithRetry([]string{endpoint}, retryConfig)
+	require.Nil(t, err)
+	cli := rg.(*etcdRegistry).etcdClient
+
+	info := &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	require.Nil(t, rg.Register(info))
+
+	// the key is registered again after it is lost
+	key := serviceKey("kitex/registry-etcd", serviceName, "127.0.0.1:8888")
+	_, err = cli.Delete(context.TODO(), key)
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		resp, err := cli.Get(context.TODO(), key)
+		return err == nil && len(resp.Kvs) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	require.Nil(t, rg.Deregister(info))
+	teardownEmbedEtcd(s)
+}
+
 func TestEmptyEndpoints(t *testing.T) {
 	_, err := NewEtcdResolver([]string{})
 	require.NotNil(t, err)
@@ -362,7 +484,16 @@ func TestNewRegistryWithTLS(t *testing.T) {
 }
 
 func setupEmbedEtcd(t *testing.T) (*embed.Etcd, string) {
-	endpoint := fmt.Sprintf("unix://localhost:%06d", os.Getpid())
+	endpoint := newEmbedEtcdEndpoint()
+	return setupEmbedEtcdAt(t, endpoint), endpoint
+}
+
+// newEmbedEtcdEndpoint returns a new endpoint, since etcd clients are shared by endpoints
+func newEmbedEtcdEndpoint() string {
+	return fmt.Sprintf("unix://localhost:%06d%03d", os.Getpid(), atomic.AddInt32(&embedEtcdCount, 1))
+}
+
+func setupEmbedEtcdAt(t *testing.T, endpoint string) *embed.Etcd {
 	u, err := url.Parse(endpoint)
 	require.Nil(t, err)
 	dir, err := ioutil.TempDir("", "etcd_resolver_test")
@@ -370,6 +501,12 @@ func setupEmbedEtcd(t *testing.T) (*embed.Etcd, string) {
 
 	cfg := embed.NewConfig()
 	cfg.ListenClientUrls = []url.URL{*u}
+	// listen peers on a unix socket too, so that several etcd can run at the same time
+	peer, err := url.Parse(newEmbedEtcdEndpoint())
+	require.Nil(t, err)
+	cfg.ListenPeerUrls = []url.URL{*peer}
+	cfg.AdvertisePeerUrls = []url.URL{*peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
 	// disable etcd log
 	cfg.LogLevel = "panic"
 	cfg.Dir = dir
@@ -378,11 +515,11 @@ func setupEmbedEtcd(t *testing.T) (*embed.Etcd, string) {
 	require.Nil(t, err)
 
 	<-s.Server.ReadyNotify()
-	return s, endpoint
+	return s
 }
 
 func setupEmbedEtcdWithTLS(t *testing.T, caFile, certFile, keyFile string) (*embed.Etcd, string) {
-	endpoint := fmt.Sprintf("unixs://localhost:%06d", os.Getpid())
+	endpoint := fmt.Sprintf("unixs://localhost:%06d%03d", os.Getpid(), atomic.AddInt32(&embedEtcdCount, 1))
 	u, err := url.Parse(endpoint)
 	require.Nil(t, err)
 	dir, err := ioutil.TempDir("", "etcd_resolver_test")
@@ -720,3 +857,895 @@ func TestEtcdResolverWithDefaultWeight(t *testing.T) {
 
 	teardownEmbedEtcd(s)
 }
+
+func TestEtcdResolverDiff(t *testing.T) {
+	rs := &etcdResolver{}
+	a := discovery.NewInstance("tcp", "127.0.0.1:8001", 10, map[string]string{"hello": "world"})
+	b := discovery.NewInstance("tcp", "127.0.0.1:8002", 10, nil)
+	c := discovery.NewInstance("tcp", "127.0.0.1:8003", 10, nil)
+	prev := discovery.Result{Cacheable: true, CacheKey: serviceName, Instances: []discovery.Instance{a, b}}
+
+	// nothing changed
+	_, changed := rs.Diff(serviceName, prev, prev)
+	require.False(t, changed)
+
+	// tags changed
+	a2 := discovery.NewInstance("tcp", "127.0.0.1:8001", 10, map[string]string{"hello": "kitex"})
+	next := discovery.Result{Cacheable: true, CacheKey: serviceName, Instances: []discovery.Instance{a2, c}}
+	ch, changed := rs.Diff(serviceName, prev, next)
+	require.True(t, changed)
+	require.Equal(t, next, ch.Result)
+	require.Equal(t, []discovery.Instance{c}, ch.Added)
+	require.Equal(t, []discovery.Instance{a2}, ch.Updated)
+	require.Equal(t, []discovery.Instance{b}, ch.Removed)
+
+	// weight changed
+	b2 := discovery.NewInstance("tcp", "127.0.0.1:8002", 20, nil)
+	next = discovery.Result{Cacheable: true, CacheKey: serviceName, Instances: []discovery.Instance{a, b2}}
+	ch, changed = rs.Diff(serviceName, prev, next)
+	require.True(t, changed)
+	require.Equal(t, []discovery.Instance{b2}, ch.Updated)
+	require.Empty(t, ch.Added)
+	require.Empty(t, ch.Removed)
+}
+
+func TestEtcdResolverWithPageSize(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithResolvePageSize(2))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	// instances of a service sharing the same prefix should not be read
+	putInstance(t, cli, &registry.Info{
+		ServiceName: serviceName + "-suffix",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:9000"),
+		Weight:      10,
+	})
+	expected := discovery.Result{
+		Cacheable: true,
+		CacheKey:  serviceName,
+	}
+	for i := 0; i < 5; i++ {
+		info := registry.Info{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", fmt.Sprintf("127.0.0.1:%d", 8000+i)),
+			Weight:      10,
+		}
+		putInstance(t, cli, &info)
+		expected.Instances = append(expected.Instances, discovery.NewInstance(info.Addr.Network(), info.Addr.String(), info.Weight, nil))
+	}
+
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, expected, result)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithSerializableRead(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithSerializableRead(0))
+	require.Nil(t, err)
+	_, ok := GetResultRevision(rs, serviceName)
+	require.False(t, ok)
+
+	info := registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	putInstance(t, rs.(*etcdResolver).etcdClient, &info)
+	resp, err := rs.(*etcdResolver).etcdClient.Get(context.TODO(), serviceKey("kitex/registry-etcd", serviceName, "127.0.0.1:8888"))
+	require.Nil(t, err)
+
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	rev, ok := GetResultRevision(rs, serviceName)
+	require.True(t, ok)
+	require.Equal(t, resp.Header.Revision, rev)
+
+	// the resolver has seen a later revision, so the serializable read lags and is read again
+	cli := rs.(*etcdResolver).etcdClient
+	kv := &countingKV{KV: cli.KV}
+	cli.KV = kv
+	defer func() { cli.KV = kv.KV }()
+	rs.(*etcdResolver).observeRevision(resp.Header.Revision + 1)
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Equal(t, int32(2), kv.gets.Load())
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithSerializablePagedRead(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithSerializableRead(100), WithResolvePageSize(1))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+	for i := 0; i < 3; i++ {
+		putInstance(t, cli, &registry.Info{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", fmt.Sprintf("127.0.0.1:%d", 8000+i)),
+			Weight:      10,
+		})
+	}
+
+	// later pages reach a member behind the revision of the first page
+	kv := &futureRevKV{KV: cli.KV}
+	cli.KV = kv
+	defer func() { cli.KV = kv.KV }()
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 3)
+
+	teardownEmbedEtcd(s)
+}
+
+// futureRevKV fails the serializable Get calls at a revision like a lagging member.
+type futureRevKV struct {
+	clientv3.KV
+}
+
+func (kv *futureRevKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if op := clientv3.OpGet(key, opts...); op.IsSerializable() && op.Rev() > 0 {
+		return nil, rpctypes.ErrFutureRev
+	}
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+// countingKV counts the Get calls of a client, and holds them until wait is closed if it is set.
+type countingKV struct {
+	clientv3.KV
+	gets atomic.Int32
+	wait chan struct{}
+	// the deadline of the last Get
+	deadline atomic.Pointer[time.Time]
+}
+
+func (kv *countingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	kv.gets.Add(1)
+	if deadline, ok := ctx.Deadline(); ok {
+		kv.deadline.Store(&deadline)
+	}
+	if kv.wait != nil {
+		<-kv.wait
+	}
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func TestEtcdResolverPreload(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint})
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	infoList := []registry.Info{
+		{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+			Weight:      10,
+		},
+		{
+			ServiceName: serviceName + "-suffix",
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8889"),
+			Weight:      10,
+		},
+	}
+	for _, info := range infoList {
+		putInstance(t, cli, &info)
+	}
+	err = Preload(context.TODO(), rs, serviceName, serviceName+"-suffix", "not-exist")
+	require.Nil(t, err)
+
+	// the first Resolve uses the preloaded instances
+	for _, info := range infoList {
+		deleteInstance(t, cli, &info)
+	}
+	for _, info := range infoList {
+		result, err := rs.Resolve(context.TODO(), info.ServiceName)
+		require.Nil(t, err)
+		require.Equal(t, []discovery.Instance{
+			discovery.NewInstance(info.Addr.Network(), info.Addr.String(), info.Weight, nil),
+		}, result.Instances)
+	}
+	_, err = rs.Resolve(context.TODO(), "not-exist")
+	require.NotNil(t, err)
+
+	// the following Resolve reads etcd
+	for _, info := range infoList {
+		_, err := rs.Resolve(context.TODO(), info.ServiceName)
+		require.NotNil(t, err)
+	}
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverPreloadWithPageSize(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithResolvePageSize(1))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	services := []string{serviceName, serviceName + "-suffix"}
+	for _, service := range services {
+		for i := 0; i < 2; i++ {
+			putInstance(t, cli, &registry.Info{
+				ServiceName: service,
+				Addr:        utils.NewNetAddr("tcp", fmt.Sprintf("127.0.0.1:%d", 8000+i)),
+				Weight:      10,
+			})
+		}
+	}
+
+	// each service is read page by page instead of in one txn
+	kv := &countingKV{KV: cli.KV}
+	cli.KV = kv
+	err = Preload(context.TODO(), rs, services...)
+	cli.KV = kv.KV
+	require.Nil(t, err)
+	require.Equal(t, int32(4), kv.gets.Load())
+
+	for _, service := range services {
+		_, err = cli.Delete(context.TODO(), serviceKeyPrefix("kitex/registry-etcd", service), clientv3.WithPrefix())
+		require.Nil(t, err)
+		result, err := rs.Resolve(context.TODO(), service)
+		require.Nil(t, err)
+		require.Len(t, result.Instances, 2)
+	}
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithSharedWatch(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithSharedWatch())
+	require.Nil(t, err)
+	defer rs.(*etcdResolver).Close()
+	cli := rs.(*etcdResolver).etcdClient
+
+	infoList := []registry.Info{
+		{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+			Weight:      10,
+		},
+		{
+			ServiceName: serviceName + "-suffix",
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8889"),
+			Weight:      10,
+		},
+	}
+	for _, info := range infoList {
+		putInstance(t, cli, &info)
+	}
+	watchCache := rs.(*etcdResolver).watchCache
+	require.Eventually(t, func() bool {
+		for _, info := range infoList {
+			if kvs, _, ok := watchCache.get(info.ServiceName); !ok || len(kvs) != 1 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, info := range infoList {
+		result, err := rs.Resolve(context.TODO(), info.ServiceName)
+		require.Nil(t, err)
+		require.Equal(t, []discovery.Instance{
+			discovery.NewInstance(info.Addr.Network(), info.Addr.String(), info.Weight, nil),
+		}, result.Instances)
+	}
+
+	deleteInstance(t, cli, &infoList[0])
+	require.Eventually(t, func() bool {
+		_, err := rs.Resolve(context.TODO(), serviceName)
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the cache is not used once it has not heard from etcd for too long, and etcd is read directly
+	watchCache.mu.Lock()
+	watchCache.updatedAt = time.Now().Add(-watchMaxAge - time.Second)
+	watchCache.mu.Unlock()
+	_, _, ok := watchCache.get(infoList[1].ServiceName)
+	require.False(t, ok)
+	deleteInstance(t, cli, &infoList[1])
+	_, err = rs.Resolve(context.TODO(), infoList[1].ServiceName)
+	require.NotNil(t, err)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithMinResolveInterval(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithMinResolveInterval(time.Hour))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	info := registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	putInstance(t, cli, &info)
+
+	// a canceled caller does not fail the shared read
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = rs.(*etcdResolver).readService(ctx, serviceName)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Eventually(t, func() bool {
+		_, ok := rs.(*etcdResolver).lastReads.Load(serviceKeyPrefix(rs.(*etcdResolver).prefix, serviceName))
+		return ok
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Resolve calls within the interval reuse the last read
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := rs.Resolve(context.TODO(), serviceName)
+			require.Nil(t, err)
+			require.Len(t, result.Instances, 1)
+		}()
+	}
+	wg.Wait()
+
+	// Resolve within the interval does not read etcd
+	deleteInstance(t, cli, &info)
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverSharedRead(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint})
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+	putInstance(t, cli, &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	})
+
+	// concurrent Resolve calls share the same read
+	kv := &countingKV{KV: cli.KV, wait: make(chan struct{})}
+	cli.KV = kv
+	defer func() { cli.KV = kv.KV }()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := rs.Resolve(context.TODO(), serviceName)
+			require.Nil(t, err)
+			require.Len(t, result.Instances, 1)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return kv.gets.Load() == 1
+	}, time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	close(kv.wait)
+	wg.Wait()
+	require.Equal(t, int32(1), kv.gets.Load())
+
+	// the shared read keeps the deadline of the first caller
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	_, err = rs.Resolve(ctx, serviceName)
+	require.Nil(t, err)
+	require.True(t, kv.deadline.Load().Equal(deadline))
+
+	// or has the default timeout if there is none
+	_, err = rs.Resolve(context.Background(), serviceName)
+	require.Nil(t, err)
+	require.WithinDuration(t, time.Now().Add(sharedReadTimeout), *kv.deadline.Load(), time.Second)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestSharedEtcdClient(t *testing.T) {
+	endpoints := []string{"127.0.0.1:23790", "127.0.0.1:23791"}
+	rs1, err := NewEtcdResolver(endpoints)
+	require.Nil(t, err)
+	rs2, err := NewEtcdResolver([]string{endpoints[1], endpoints[0]})
+	require.Nil(t, err)
+	rg, err := NewEtcdRegistry(endpoints)
+	require.Nil(t, err)
+	rs3, err := NewEtcdResolver(endpoints, WithDialTimeoutOpt(0), WithEtcdServicePrefix("other"))
+	require.Nil(t, err)
+	rs4, err := NewEtcdResolver(endpoints[:1])
+	require.Nil(t, err)
+
+	cli := rs1.(*etcdResolver).etcdClient
+	require.Same(t, cli, rs2.(*etcdResolver).etcdClient)
+	require.Same(t, cli, rg.(*etcdRegistry).etcdClient)
+	require.Same(t, cli, rs3.(*etcdResolver).etcdClient)
+	require.NotSame(t, cli, rs4.(*etcdResolver).etcdClient)
+
+	// the client is closed when the last user closes
+	require.Nil(t, rs1.(*etcdResolver).Close())
+	require.Nil(t, rs1.(*etcdResolver).Close())
+	require.Nil(t, rg.(*etcdRegistry).Close())
+	require.Nil(t, cli.Ctx().Err())
+	require.Nil(t, rs2.(*etcdResolver).Close())
+	require.Nil(t, cli.Ctx().Err())
+	require.Nil(t, rs3.(*etcdResolver).Close())
+	require.NotNil(t, cli.Ctx().Err())
+	require.Nil(t, rs4.(*etcdResolver).Close())
+}
+
+func TestEtcdResolverWithOutlierReport(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs1, err := NewEtcdResolver([]string{endpoint}, WithOutlierReport(OutlierConfig{ReporterID: "client-1"}))
+	require.Nil(t, err)
+	rs2, err := NewEtcdResolver([]string{endpoint}, WithOutlierReport(OutlierConfig{ReporterID: "client-2"}))
+	require.Nil(t, err)
+	cli := rs1.(*etcdResolver).etcdClient
+
+	infoList := []registry.Info{
+		{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+			Weight:      100,
+		},
+		{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8889"),
+			Weight:      100,
+		},
+	}
+	for _, info := range infoList {
+		putInstance(t, cli, &info)
+	}
+
+	// flagged by one resolver
+	require.Nil(t, ReportSuspect(context.TODO(), rs1, serviceName, "127.0.0.1:8888"))
+	require.Nil(t, ReportSuspect(context.TODO(), rs1, serviceName, "127.0.0.1:8888"))
+	result, err := rs1.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, 100, result.Instances[0].Weight())
+
+	// flagged by two resolvers
+	require.Nil(t, ReportSuspect(context.TODO(), rs2, serviceName, "127.0.0.1:8888"))
+	result, err = rs1.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, 10, result.Instances[0].Weight())
+	require.Equal(t, 100, result.Instances[1].Weight())
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverOutlierReportWithAddressTranslation(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	translation := WithAddressTranslation(map[string]string{
+		"10.0.0.1:8888": "127.0.0.1:8001",
+		"10.0.0.2:8888": "127.0.0.1:8002",
+	})
+	probe := WithHealthProbe(ProbeConfig{
+		Interval:         time.Hour,
+		FailureThreshold: 1,
+		Probe: func(ctx context.Context, addr net.Addr) error {
+			if addr.String() == "127.0.0.1:8001" {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+	})
+	var resolvers []discovery.Resolver
+	for i := 1; i <= 3; i++ {
+		rs, err := NewEtcdResolver([]string{endpoint}, translation, probe,
+			WithOutlierReport(OutlierConfig{ReporterID: fmt.Sprintf("client-%d", i)}))
+		require.Nil(t, err)
+		resolvers = append(resolvers, rs)
+	}
+	cli := resolvers[0].(*etcdResolver).etcdClient
+	for _, addr := range []string{"10.0.0.1:8888", "10.0.0.2:8888"} {
+		putInstance(t, cli, &registry.Info{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", addr),
+			Weight:      100,
+		})
+	}
+
+	// two resolvers find the translated address unhealthy
+	for _, rs := range resolvers[:2] {
+		_, err := rs.Resolve(context.TODO(), serviceName)
+		require.Nil(t, err)
+		rs.(*etcdResolver).prober.probeAll(context.Background())
+	}
+	// the suspect records are written under the registered address
+	resp, err := cli.Get(context.TODO(), serviceKey("kitex/registry-etcd-suspects", serviceName, "10.0.0.1:8888"), clientv3.WithPrefix())
+	require.Nil(t, err)
+	require.Len(t, resp.Kvs, 2)
+
+	// and down-weight the instance for other resolvers
+	result, err := resolvers[2].Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:8001", 10, nil),
+		discovery.NewInstance("tcp", "127.0.0.1:8002", 100, nil),
+	}, result.Instances)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithInstanceOverrides(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithInstanceOverrides())
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+	prefix := rs.(*etcdResolver).GetPrefix()
+
+	for i := 0; i < 3; i++ {
+		putInstance(t, cli, &registry.Info{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", fmt.Sprintf("127.0.0.1:%d", 8000+i)),
+			Weight:      10,
+		})
+	}
+	weight := 50
+	expired := time.Now().Add(-time.Minute)
+	err = PutInstanceOverride(context.TODO(), cli, prefix, serviceName, "127.0.0.1:8000",
+		&InstanceOverride{Weight: &weight, Tags: map[string]string{"canary": "true"}}, time.Minute)
+	require.Nil(t, err)
+	err = PutInstanceOverride(context.TODO(), cli, prefix, serviceName, "127.0.0.1:8001", &InstanceOverride{Disabled: true}, 0)
+	require.Nil(t, err)
+	err = PutInstanceOverride(context.TODO(), cli, prefix, serviceName, "127.0.0.1:8002",
+		&InstanceOverride{Disabled: true, ExpiresAt: &expired}, 0)
+	require.Nil(t, err)
+
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:8000", 50, map[string]string{"canary": "true"}),
+		discovery.NewInstance("tcp", "127.0.0.1:8002", 10, nil),
+	}, result.Instances)
+
+	require.Nil(t, DeleteInstanceOverride(context.TODO(), cli, prefix, serviceName, "127.0.0.1:8001"))
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 3)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithServiceAliases(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithServiceAliases())
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+	prefix := rs.(*etcdResolver).GetPrefix()
+
+	putInstance(t, cli, &registry.Info{
+		ServiceName: "new-a",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8000"),
+		Weight:      10,
+	})
+	putInstance(t, cli, &registry.Info{
+		ServiceName: "new-b",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8001"),
+		Weight:      10,
+	})
+	err = PutServiceAlias(context.TODO(), cli, prefix, "old", &ServiceAlias{
+		Targets: []AliasTarget{{Service: "new-a", Weight: 3}, {Service: "new-b", Weight: 1}},
+	})
+	require.Nil(t, err)
+
+	result, err := rs.Resolve(context.TODO(), "old")
+	require.Nil(t, err)
+	require.Equal(t, discovery.Result{
+		Cacheable: true,
+		CacheKey:  "old",
+		Instances: []discovery.Instance{
+			discovery.NewInstance("tcp", "127.0.0.1:8000", 300, nil),
+			discovery.NewInstance("tcp", "127.0.0.1:8001", 100, nil),
+		},
+	}, result)
+
+	// services without alias are resolved as usual
+	result, err = rs.Resolve(context.TODO(), "new-a")
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{discovery.NewInstance("tcp", "127.0.0.1:8000", 10, nil)}, result.Instances)
+
+	// alias loop
+	err = PutServiceAlias(context.TODO(), cli, prefix, "new-b", &ServiceAlias{
+		Targets: []AliasTarget{{Service: "old", Weight: 1}},
+	})
+	require.Nil(t, err)
+	_, err = rs.Resolve(context.TODO(), "old")
+	require.NotNil(t, err)
+
+	// the alias of new-b is not taken for new
+	putInstance(t, cli, &registry.Info{
+		ServiceName: "new",
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8002"),
+		Weight:      10,
+	})
+	kv := &rangeKV{KV: cli.KV}
+	cli.KV = kv
+	result, err = rs.Resolve(context.TODO(), "new")
+	cli.KV = kv.KV
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{discovery.NewInstance("tcp", "127.0.0.1:8002", 10, nil)}, result.Instances)
+	// the alias is read by its exact key
+	require.Equal(t, []string{serviceKeyPrefix(prefix, "new")}, kv.ranges)
+
+	require.Nil(t, DeleteServiceAlias(context.TODO(), cli, prefix, "new-b"))
+	require.Nil(t, DeleteServiceAlias(context.TODO(), cli, prefix, "old"))
+	_, err = rs.Resolve(context.TODO(), "old")
+	require.NotNil(t, err)
+
+	teardownEmbedEtcd(s)
+}
+
+// rangeKV records the keys of the range reads of a client.
+type rangeKV struct {
+	clientv3.KV
+	ranges []string
+}
+
+func (kv *rangeKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if op := clientv3.OpGet(key, opts...); len(op.RangeBytes()) > 0 {
+		kv.ranges = append(kv.ranges, key)
+	}
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func TestEtcdResolverSharesMetadataReads(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithInstanceOverrides(), WithServiceAliases(),
+		WithOutlierReport(OutlierConfig{}), WithMinResolveInterval(time.Hour))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+	prefix := rs.(*etcdResolver).GetPrefix()
+
+	putInstance(t, cli, &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	})
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	for _, p := range []string{
+		serviceKeyPrefix(prefix, serviceName),
+		serviceKeyPrefix(prefix+overridePrefixSuffix, serviceName),
+		serviceKeyPrefix(prefix+suspectPrefixSuffix, serviceName),
+		aliasKey(prefix, serviceName),
+	} {
+		_, ok := rs.(*etcdResolver).lastReads.Load(p)
+		require.True(t, ok, p)
+	}
+
+	// overrides within the interval are not read
+	err = PutInstanceOverride(context.TODO(), cli, prefix, serviceName, "127.0.0.1:8888", &InstanceOverride{Disabled: true}, 0)
+	require.Nil(t, err)
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithResolveOverride(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	t.Setenv(resolveOverrideKey, "other=127.0.0.1:9999, other=127.0.0.1:9998,invalid")
+	rs, err := NewEtcdResolver([]string{endpoint},
+		WithResolveOverride(serviceName, "127.0.0.1:8888"),
+		WithResolveOverride("other", "127.0.0.1:7777"),
+	)
+	require.Nil(t, err)
+
+	// the override is returned although the service is not registered
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, discovery.Result{
+		Cacheable: true,
+		CacheKey:  serviceName,
+		Instances: []discovery.Instance{discovery.NewInstance("tcp", "127.0.0.1:8888", defaultWeight, nil)},
+	}, result)
+
+	// the env replaces the option
+	result, err = rs.Resolve(context.TODO(), "other")
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:9999", defaultWeight, nil),
+		discovery.NewInstance("tcp", "127.0.0.1:9998", defaultWeight, nil),
+	}, result.Instances)
+
+	// other services are resolved from etcd
+	_, err = rs.Resolve(context.TODO(), "not-overridden")
+	require.NotNil(t, err)
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverDecoders(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithDecoder(func(key string, value []byte) (discovery.Instance, error) {
+		var legacy struct {
+			Host string `json:"host"`
+			Port int    `json:"port"`
+		}
+		if err := json.Unmarshal(value, &legacy); err != nil || legacy.Host == "" {
+			return nil, errors.New("not legacy")
+		}
+		return discovery.NewInstance("tcp", net.JoinHostPort(legacy.Host, strconv.Itoa(legacy.Port)), 5, nil), nil
+	}))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	put := func(addr, val string) {
+		_, err := cli.Put(context.TODO(), serviceKey("kitex/registry-etcd", serviceName, addr), val)
+		require.Nil(t, err)
+	}
+	put("127.0.0.1:8000", `{"network":"tcp","address":"127.0.0.1:8000","weight":20}`)
+	put("127.0.0.1:8001", " 127.0.0.1:8001\n")
+	put("127.0.0.1:8002", `{"host":"127.0.0.1","port":8002}`)
+	put("127.0.0.1:8003", "garbage")
+
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:8000", 20, nil),
+		discovery.NewInstance("tcp", "127.0.0.1:8001", defaultWeight, nil),
+		discovery.NewInstance("tcp", "127.0.0.1:8002", 5, nil),
+	}, result.Instances)
+
+	_, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, map[string]uint64{
+		serviceKey("kitex/registry-etcd", serviceName, "127.0.0.1:8003"): 2,
+	}, GetDecodeErrors(rs))
+
+	// the errors of deleted keys are dropped
+	_, err = cli.Delete(context.TODO(), serviceKey("kitex/registry-etcd", serviceName, "127.0.0.1:8003"))
+	require.Nil(t, err)
+	_, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Empty(t, GetDecodeErrors(rs))
+
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithAddressTranslation(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint},
+		WithAddressTranslation(map[string]string{"10.0.0.0/8": "1.2.3.4"}),
+		WithAddressTranslationKey("kitex/address-translation"),
+	)
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	putInstance(t, cli, &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "10.0.0.1:8888"),
+		Weight:      10,
+	})
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{discovery.NewInstance("tcp", "1.2.3.4:8888", 10, nil)}, result.Instances)
+
+	// the watched table takes precedence
+	_, err = cli.Put(context.TODO(), "kitex/address-translation", `{"10.0.0.1":"5.6.7.8:30000"}`)
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		result, err = rs.Resolve(context.TODO(), serviceName)
+		return err == nil && result.Instances[0].Address().String() == "5.6.7.8:30000"
+	}, 3*time.Second, 10*time.Millisecond)
+
+	_, err = cli.Delete(context.TODO(), "kitex/address-translation")
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		result, err = rs.Resolve(context.TODO(), serviceName)
+		return err == nil && result.Instances[0].Address().String() == "1.2.3.4:8888"
+	}, 3*time.Second, 10*time.Millisecond)
+
+	require.Nil(t, rs.(io.Closer).Close())
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdResolverWithAddressLabel(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rg, err := NewEtcdRegistry([]string{endpoint}, WithAdvertisedAddresses(map[string]string{
+		"node":     "192.168.0.1:30888",
+		"external": "1.2.3.4:443",
+	}))
+	require.Nil(t, err)
+	info := &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "10.0.0.1:8888"),
+		Weight:      10,
+	}
+	require.Nil(t, rg.Register(info))
+
+	for label, addr := range map[string]string{
+		"":         "10.0.0.1:8888",
+		"node":     "192.168.0.1:30888",
+		"external": "1.2.3.4:443",
+		"unknown":  "10.0.0.1:8888",
+	} {
+		rs, err := NewEtcdResolver([]string{endpoint}, WithAddressLabel(label))
+		require.Nil(t, err)
+		result, err := rs.Resolve(context.TODO(), serviceName)
+		require.Nil(t, err)
+		require.Equal(t, []discovery.Instance{discovery.NewInstance("tcp", addr, 10, nil)}, result.Instances)
+	}
+
+	// the label is read from env if not set
+	t.Setenv(addressLabelKey, "node")
+	rs, err := NewEtcdResolver([]string{endpoint})
+	require.Nil(t, err)
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, "192.168.0.1:30888", result.Instances[0].Address().String())
+
+	require.Nil(t, rg.Deregister(info))
+	teardownEmbedEtcd(s)
+}
+
+func TestEtcdRegistryClose(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	retryConfig := retry.NewRetryConfig(
+		retry.WithMaxAttemptTimes(0),
+		retry.WithObserveDelay(50*time.Millisecond),
+		retry.WithRetryDelay(50*time.Millisecond),
+	)
+	rg, err := NewEtcdRegistryWithRetry([]string{endpoint}, retryConfig, WithLeaseTTL(2))
+	require.Nil(t, err)
+	// the resolver holds the same client after the registry is closed
+	rs, err := NewEtcdResolver([]string{endpoint})
+	require.Nil(t, err)
+	require.Equal(t, rg.(*etcdRegistry).etcdClient, rs.(*etcdResolver).etcdClient)
+
+	info := &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	require.Nil(t, rg.Register(info))
+	_, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+
+	require.Nil(t, rg.(io.Closer).Close())
+	// the lease expires without keepalive, and the key is not registered again
+	cli := rs.(*etcdResolver).etcdClient
+	key := serviceKey("kitex/registry-etcd", serviceName, "127.0.0.1:8888")
+	require.Eventually(t, func() bool {
+		resp, err := cli.Get(context.TODO(), key)
+		return err == nil && len(resp.Kvs) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Never(t, func() bool {
+		resp, err := cli.Get(context.TODO(), key)
+		return err != nil || len(resp.Kvs) > 0
+	}, time.Second, 50*time.Millisecond)
+
+	require.Nil(t, rs.(io.Closer).Close())
+	teardownEmbedEtcd(s)
+}
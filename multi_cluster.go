@@ -0,0 +1,253 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/kitex-contrib/registry-etcd/retry"
+)
+
+// ClusterStatus is the registration status of an instance in an etcd cluster.
+type ClusterStatus struct {
+	Name       string
+	Endpoints  []string
+	Registered bool
+	LastError  error
+	UpdatedAt  time.Time
+}
+
+// ClusterConfig is an etcd cluster of a multi-cluster registry.
+type ClusterConfig struct {
+	Name      string
+	Endpoints []string
+	// Options are applied after the options shared by all clusters
+	Options []Option
+}
+
+// multiClusterRegistry registers instances into several etcd clusters.
+// Each cluster has its own registry, so its lease, keepalive and keepRegister loop do not affect the others.
+type multiClusterRegistry struct {
+	clusters    []*clusterRegistry
+	retryConfig *retry.Config
+
+	mu sync.Mutex
+	// cancels the registrations retried in background
+	cancel context.CancelFunc
+}
+
+// clusterRegistry serializes the registrations of a cluster, so that a background retry never races Deregister.
+type clusterRegistry struct {
+	*etcdRegistry
+
+	mu         sync.Mutex
+	registered bool
+}
+
+// NewMultiClusterEtcdRegistry creates a registry which registers the same instance into all clusters.
+// Register fails only if no cluster succeeds, and the failed clusters are retried in background.
+// Both the background registration and the keepRegister loop of each cluster retry with the delays of
+// retryConfig but regardless of MaxAttemptTimes, so that a cluster recovering from a long outage still
+// gets the instance. A Policy set in retryConfig may still give up.
+func NewMultiClusterEtcdRegistry(clusters []ClusterConfig, retryConfig *retry.Config, opts ...Option) (registry.Registry, error) {
+	if len(clusters) == 0 {
+		return nil, errors.New("missing clusters of multi-cluster registry")
+	}
+	if retryConfig == nil {
+		retryConfig = retry.NewRetryConfig()
+	}
+	clusterRetryConfig := *retryConfig
+	clusterRetryConfig.MaxAttemptTimes = 0
+	m := &multiClusterRegistry{retryConfig: &clusterRetryConfig}
+	for i, cluster := range clusters {
+		name := cluster.Name
+		if name == "" {
+			name = fmt.Sprintf("cluster-%d", i)
+		}
+		r, err := NewEtcdRegistryWithRetry(cluster.Endpoints, &clusterRetryConfig, append(opts[:len(opts):len(opts)], cluster.Options...)...)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("create registry of cluster %s failed: %w", name, err)
+		}
+		er := r.(*etcdRegistry)
+		er.status = ClusterStatus{Name: name, Endpoints: cluster.Endpoints}
+		m.clusters = append(m.clusters, &clusterRegistry{etcdRegistry: er})
+	}
+	return m, nil
+}
+
+// Register implements the Registry interface.
+func (m *multiClusterRegistry) Register(info *registry.Info) error {
+	if err := validateRegistryInfo(info); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.cancel = cancel
+	m.mu.Unlock()
+
+	var errs []error
+	var failed []*clusterRegistry
+	for _, c := range m.clusters {
+		c.mu.Lock()
+		err := c.Register(info)
+		c.registered = err == nil
+		c.mu.Unlock()
+		if err != nil {
+			klog.Warnf("register %s into etcd cluster %s failed with err: %v", info.ServiceName, c.status.Name, err)
+			errs = append(errs, fmt.Errorf("cluster %s: %w", c.status.Name, err))
+			failed = append(failed, c)
+		}
+	}
+	if len(failed) == len(m.clusters) {
+		cancel()
+		return errors.Join(errs...)
+	}
+	for _, c := range failed {
+		go m.retryRegister(ctx, c, info)
+	}
+	return nil
+}
+
+// retryRegister registers the instance into a failed cluster until it succeeds or the registration is canceled.
+func (m *multiClusterRegistry) retryRegister(ctx context.Context, c *clusterRegistry, info *registry.Info) {
+	backoff := m.retryConfig.NewBackoff()
+	var failedTimes uint
+	for {
+		delay, ok := backoff.Next()
+		if !ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		c.mu.Lock()
+		// Deregister cancels ctx before it takes the lock, so the instance is never registered after it
+		if ctx.Err() != nil {
+			c.mu.Unlock()
+			return
+		}
+		err := c.Register(info)
+		c.registered = err == nil
+		c.mu.Unlock()
+		if err == nil {
+			klog.Infof("register %s into etcd cluster %s", info.ServiceName, c.status.Name)
+			return
+		}
+		klog.Warnf("retry register %s into etcd cluster %s failed with err: %v", info.ServiceName, c.status.Name, err)
+		failedTimes++
+	}
+	klog.Errorf("register %s into etcd cluster %s failed times:%d", info.ServiceName, c.status.Name, failedTimes)
+}
+
+// Deregister implements the Registry interface.
+func (m *multiClusterRegistry) Deregister(info *registry.Info) error {
+	if info.ServiceName == "" {
+		return fmt.Errorf("missing service name in Deregister")
+	}
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, c := range m.clusters {
+		c.mu.Lock()
+		if c.registered {
+			if err := c.Deregister(info); err != nil {
+				errs = append(errs, fmt.Errorf("cluster %s: %w", c.status.Name, err))
+			} else {
+				c.registered = false
+			}
+		}
+		c.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// Close releases the etcd clients of all clusters.
+func (m *multiClusterRegistry) Close() error {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, c := range m.clusters {
+		// wait for an in-flight retry, which sees the canceled ctx afterwards
+		c.mu.Lock()
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		c.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// GetClusterStatus returns the registration status of the registry in each etcd cluster.
+func GetClusterStatus(r registry.Registry) []ClusterStatus {
+	switch er := r.(type) {
+	case *etcdRegistry:
+		return []ClusterStatus{er.getStatus()}
+	case *multiClusterRegistry:
+		res := make([]ClusterStatus, 0, len(er.clusters))
+		for _, c := range er.clusters {
+			res = append(res, c.getStatus())
+		}
+		return res
+	}
+	panic("invalid registry type: not etcdRegistry")
+}
+
+func (e *etcdRegistry) setStatus(registered bool, err error) {
+	e.statusMu.Lock()
+	e.status.Registered = registered
+	e.status.LastError = err
+	e.status.UpdatedAt = time.Now()
+	e.statusMu.Unlock()
+}
+
+// setError records the error without changing whether the instance is registered.
+func (e *etcdRegistry) setError(err error) {
+	e.statusMu.Lock()
+	e.status.LastError = err
+	e.status.UpdatedAt = time.Now()
+	e.statusMu.Unlock()
+}
+
+func (e *etcdRegistry) getStatus() ClusterStatus {
+	e.statusMu.Lock()
+	defer e.statusMu.Unlock()
+	status := e.status
+	if status.Endpoints == nil {
+		status.Endpoints = e.etcdClient.Endpoints()
+	}
+	return status
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/kitex-contrib/registry-etcd/retry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiClusterEtcdRegistry(t *testing.T) {
+	s1, endpoint1 := setupEmbedEtcd(t)
+	s2, endpoint2 := setupEmbedEtcd(t)
+
+	rg, err := NewMultiClusterEtcdRegistry([]ClusterConfig{
+		{Name: "region-a", Endpoints: []string{endpoint1}},
+		{Name: "region-b", Endpoints: []string{endpoint2}},
+		{Name: "region-c", Endpoints: []string{"unix://localhost:unreachable"}},
+	}, retry.NewRetryConfig(retry.WithMaxAttemptTimes(1), retry.WithRetryDelay(100*time.Millisecond)))
+	require.Nil(t, err)
+
+	info := &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	require.Nil(t, rg.Register(info))
+
+	status := GetClusterStatus(rg)
+	require.Equal(t, 3, len(status))
+	require.True(t, status[0].Registered)
+	require.True(t, status[1].Registered)
+	require.False(t, status[2].Registered)
+	require.NotNil(t, status[2].LastError)
+	require.Equal(t, "region-c", status[2].Name)
+
+	for _, endpoint := range []string{endpoint1, endpoint2} {
+		rs, err := NewEtcdResolver([]string{endpoint})
+		require.Nil(t, err)
+		result, err := rs.Resolve(context.TODO(), serviceName)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(result.Instances))
+		require.Equal(t, "127.0.0.1:8888", result.Instances[0].Address().String())
+	}
+
+	require.Nil(t, rg.Deregister(info))
+	status = GetClusterStatus(rg)
+	require.False(t, status[0].Registered)
+	require.False(t, status[1].Registered)
+
+	require.Nil(t, rg.(io.Closer).Close())
+	teardownEmbedEtcd(s1)
+	teardownEmbedEtcd(s2)
+}
+
+func TestMultiClusterEtcdRegistryRetry(t *testing.T) {
+	s1, endpoint1 := setupEmbedEtcd(t)
+	endpoint2 := newEmbedEtcdEndpoint()
+
+	rg, err := NewMultiClusterEtcdRegistry([]ClusterConfig{
+		{Name: "region-a", Endpoints: []string{endpoint1}},
+		{Name: "region-b", Endpoints: []string{endpoint2}},
+	}, retry.NewRetryConfig(retry.WithMaxAttemptTimes(1), retry.WithRetryDelay(100*time.Millisecond)))
+	require.Nil(t, err)
+
+	info := &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	require.Nil(t, rg.Register(info))
+	require.False(t, GetClusterStatus(rg)[1].Registered)
+
+	// region-b recovers after more attempts than MaxAttemptTimes
+	time.Sleep(time.Second)
+	s2 := setupEmbedEtcdAt(t, endpoint2)
+	require.Eventually(t, func() bool {
+		return GetClusterStatus(rg)[1].Registered
+	}, 30*time.Second, 100*time.Millisecond)
+
+	require.Nil(t, rg.Deregister(info))
+	rs, err := NewEtcdResolver([]string{endpoint2})
+	require.Nil(t, err)
+	_, err = rs.Resolve(context.TODO(), serviceName)
+	require.NotNil(t, err)
+
+	require.Nil(t, rg.(io.Closer).Close())
+	teardownEmbedEtcd(s1)
+	teardownEmbedEtcd(s2)
+}
+
+func TestMultiClusterEtcdRegistryKeepRegister(t *testing.T) {
+	s1, endpoint1 := setupEmbedEtcd(t)
+	s2, endpoint2 := setupEmbedEtcd(t)
+
+	rg, err := NewMultiClusterEtcdRegistry([]ClusterConfig{
+		{Name: "region-a", Endpoints: []string{endpoint1}},
+		{Name: "region-b", Endpoints: []string{endpoint2}},
+	}, retry.NewRetryConfig(
+		retry.WithMaxAttemptTimes(1),
+		retry.WithObserveDelay(100*time.Millisecond),
+		retry.WithRetryDelay(100*time.Millisecond),
+	))
+	require.Nil(t, err)
+
+	info := &registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	require.Nil(t, rg.Register(info))
+
+	// region-b is down for more attempts than MaxAttemptTimes, and comes back empty
+	teardownEmbedEtcd(s2)
+	require.Eventually(t, func() bool {
+		return GetClusterStatus(rg)[1].LastError != nil
+	}, 10*time.Second, 100*time.Millisecond)
+	time.Sleep(time.Second)
+	s2 = setupEmbedEtcdAt(t, endpoint2)
+
+	rs, err := NewEtcdResolver([]string{endpoint2})
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		_, err := rs.Resolve(context.TODO(), serviceName)
+		return err == nil
+	}, 30*time.Second, 100*time.Millisecond)
+
+	require.Nil(t, rg.Deregister(info))
+	require.Nil(t, rg.(io.Closer).Close())
+	teardownEmbedEtcd(s1)
+	teardownEmbedEtcd(s2)
+}
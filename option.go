@@ -21,6 +21,7 @@ import (
 	"io/ioutil" //nolint
 	"time"
 
+	"github.com/cloudwego/kitex/pkg/event"
 	"github.com/cloudwego/kitex/pkg/klog"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
@@ -32,6 +33,68 @@ type Config struct {
 	EtcdConfig    *clientv3.Config
 	Prefix        string
 	DefaultWeight int
+
+	// zone aware resolving, only used by resolver
+	ZoneAware             bool
+	LocalZone             string
+	ZoneTagKey            string
+	ZoneFallbackThreshold int
+
+	// empty-push protection, only used by resolver
+	ProtectionThreshold float64
+	ProtectionMaxHold   time.Duration
+	EventBus            event.Bus
+
+	// stale fallback, only used by resolver
+	StaleFallback bool
+	MaxStaleness  time.Duration
+
+	// directory of the resolver snapshot, only used by resolver
+	SnapshotDir string
+
+	// page size of reading instances, only used by resolver
+	ResolvePageSize int64
+
+	// serializable read, only used by resolver
+	SerializableRead bool
+	MaxRevisionLag   int64
+
+	// single watch on the root prefix, only used by resolver
+	SharedWatch bool
+
+	// minimum interval between etcd reads of a service, only used by resolver
+	MinResolveInterval time.Duration
+
+	// active health probing of resolved instances, only used by resolver
+	HealthProbe *ProbeConfig
+
+	// suspect records of unhealthy instances, only used by resolver
+	OutlierReport *OutlierConfig
+
+	// operator overrides of instances, only used by resolver
+	InstanceOverrides bool
+
+	// service aliases, only used by resolver
+	ServiceAliases bool
+
+	// local addresses of services which are not resolved from etcd, only used by resolver
+	ResolveOverrides map[string][]string
+
+	// decoders of values in other formats, only used by resolver
+	Decoders []Decoder
+
+	// translation of registered addresses to reachable addresses, only used by resolver
+	AddressTranslation    map[string]string
+	AddressTranslationKey string
+
+	// labeled addresses published by registry
+	AdvertisedAddresses map[string]string
+	// the label of addresses chosen by resolver
+	AddressLabel string
+
+	// lease TTLs in seconds, only used by registry
+	LeaseTTL         int64
+	ServiceLeaseTTLs map[string]int64
 }
 
 // WithTLSOpt returns a option that authentication by tls/ssl.
@@ -94,3 +157,224 @@ func WithDefaultWeight(defaultWeight int) Option {
 		cfg.DefaultWeight = defaultWeight
 	}
 }
+
+// WithZoneAware returns an option that makes the resolver prefer instances in the given zone.
+// If localZone is empty, the value of env KITEX_ETCD_RESOLVER_LOCAL_ZONE is used.
+func WithZoneAware(localZone string) Option {
+	return func(cfg *Config) {
+		cfg.ZoneAware = true
+		cfg.LocalZone = localZone
+	}
+}
+
+// WithZoneTagKey returns an option that sets the instance tag key which holds the zone of an instance
+func WithZoneTagKey(key string) Option {
+	return func(cfg *Config) {
+		cfg.ZoneTagKey = key
+	}
+}
+
+// WithZoneFallbackThreshold returns an option that sets the minimum number of same-zone instances,
+// instances in other zones are also returned when there are fewer same-zone instances than threshold.
+func WithZoneFallbackThreshold(threshold int) Option {
+	return func(cfg *Config) {
+		cfg.ZoneFallbackThreshold = threshold
+	}
+}
+
+// WithProtectionThreshold returns an option that enables empty-push protection of the resolver.
+// If fewer instances are registered in etcd than threshold (0 < threshold <= 1) times the instances
+// registered for the last result, the last result is kept.
+func WithProtectionThreshold(threshold float64) Option {
+	return func(cfg *Config) {
+		cfg.ProtectionThreshold = threshold
+	}
+}
+
+// WithProtectionMaxHold returns an option that sets the longest time the resolver keeps the last result
+// of a service under protection, 5 minutes by default. After it, the smaller result is accepted.
+func WithProtectionMaxHold(maxHold time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ProtectionMaxHold = maxHold
+	}
+}
+
+// WithEventBus returns an option that sets the bus to which the resolver dispatches its events
+func WithEventBus(bus event.Bus) Option {
+	return func(cfg *Config) {
+		cfg.EventBus = bus
+	}
+}
+
+// WithStaleFallback returns an option that makes the resolver serve the last known good instances
+// of a service when reading etcd fails, as long as they are not older than maxStaleness.
+// maxStaleness <= 0 means no limit.
+func WithStaleFallback(maxStaleness time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.StaleFallback = true
+		cfg.MaxStaleness = maxStaleness
+	}
+}
+
+// WithSnapshotDir returns an option that makes the resolver persist the latest instances of each service
+// in dir, which are used when etcd is unreachable at startup.
+func WithSnapshotDir(dir string) Option {
+	return func(cfg *Config) {
+		cfg.SnapshotDir = dir
+	}
+}
+
+// WithResolvePageSize returns an option that makes the resolver read the instances of a service
+// in pages of the given size, all pages are read at the same revision.
+// size <= 0 means reading all instances at once.
+func WithResolvePageSize(size int64) Option {
+	return func(cfg *Config) {
+		cfg.ResolvePageSize = size
+	}
+}
+
+// WithSerializableRead returns an option that makes the resolver read instances serializably,
+// so that any etcd member can serve the read without going through the leader.
+// If the revision of a read lags more than maxRevisionLag behind the latest revision seen by the resolver,
+// the instances are read again linearizably.
+// The lag is only measured against revisions the resolver has seen in its own reads and watches,
+// so a member which is behind since the first read is not detected until the resolver sees a later revision.
+func WithSerializableRead(maxRevisionLag int64) Option {
+	return func(cfg *Config) {
+		cfg.SerializableRead = true
+		cfg.MaxRevisionLag = maxRevisionLag
+	}
+}
+
+// WithSharedWatch returns an option that makes the resolver keep a single watch on the root prefix
+// set by WithEtcdServicePrefix, and resolve all services from the watched keys.
+func WithSharedWatch() Option {
+	return func(cfg *Config) {
+		cfg.SharedWatch = true
+	}
+}
+
+// WithMinResolveInterval returns an option that sets the minimum interval between etcd reads of a service,
+// Resolve within the interval reuses the instances of the last read.
+func WithMinResolveInterval(interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.MinResolveInterval = interval
+	}
+}
+
+// WithHealthProbe returns an option that makes the resolver probe the resolved instances in background,
+// and remove the failing instances from results until they recover.
+// Zero fields of probeConfig are set to defaults.
+func WithHealthProbe(probeConfig ProbeConfig) Option {
+	return func(cfg *Config) {
+		cfg.HealthProbe = &probeConfig
+	}
+}
+
+// WithOutlierReport returns an option that makes the resolver write suspect records for the instances
+// it finds unhealthy, and down-weight the instances flagged by enough resolvers.
+// Zero fields of outlierConfig are set to defaults.
+func WithOutlierReport(outlierConfig OutlierConfig) Option {
+	return func(cfg *Config) {
+		cfg.OutlierReport = &outlierConfig
+	}
+}
+
+// WithInstanceOverrides returns an option that makes the resolver merge the overrides
+// stored under <prefix>-overrides/<service>/<addr> over the registered instances.
+func WithInstanceOverrides() Option {
+	return func(cfg *Config) {
+		cfg.InstanceOverrides = true
+	}
+}
+
+// WithServiceAliases returns an option that makes the resolver follow the aliases
+// stored under <prefix>-aliases/<service>.
+func WithServiceAliases() Option {
+	return func(cfg *Config) {
+		cfg.ServiceAliases = true
+	}
+}
+
+// WithResolveOverride returns an option that makes the resolver return addrs for the service without reading etcd.
+// It is meant for development, for example to point one service at localhost.
+func WithResolveOverride(service string, addrs ...string) Option {
+	return func(cfg *Config) {
+		if cfg.ResolveOverrides == nil {
+			cfg.ResolveOverrides = make(map[string][]string)
+		}
+		cfg.ResolveOverrides[service] = append(cfg.ResolveOverrides[service], addrs...)
+	}
+}
+
+// WithDecoder returns an option that adds a decoder of values which are neither
+// the JSON written by the registry nor plain "host:port" strings.
+// Decoders are tried in the order they are added.
+func WithDecoder(decoder Decoder) Option {
+	return func(cfg *Config) {
+		cfg.Decoders = append(cfg.Decoders, decoder)
+	}
+}
+
+// WithAddressTranslation returns an option that makes the resolver translate registered addresses to reachable ones,
+// e.g. for clients outside the network of servers. A key of the table is a "host:port", a host or a CIDR,
+// and a value is a "host:port", or a host which keeps the registered port.
+func WithAddressTranslation(table map[string]string) Option {
+	return func(cfg *Config) {
+		if cfg.AddressTranslation == nil {
+			cfg.AddressTranslation = make(map[string]string, len(table))
+		}
+		for k, v := range table {
+			cfg.AddressTranslation[k] = v
+		}
+	}
+}
+
+// WithAddressTranslationKey returns an option that makes the resolver watch the translation table stored
+// as a JSON object at the etcd key. Its entries take precedence over the table of WithAddressTranslation.
+func WithAddressTranslationKey(key string) Option {
+	return func(cfg *Config) {
+		cfg.AddressTranslationKey = key
+	}
+}
+
+// WithAdvertisedAddresses returns an option that makes the registry publish the addresses of the instance in other networks,
+// keyed by labels such as internal, node and external. The registered address stays the primary address.
+func WithAdvertisedAddresses(addrs map[string]string) Option {
+	return func(cfg *Config) {
+		if cfg.AdvertisedAddresses == nil {
+			cfg.AdvertisedAddresses = make(map[string]string, len(addrs))
+		}
+		for label, addr := range addrs {
+			cfg.AdvertisedAddresses[label] = addr
+		}
+	}
+}
+
+// WithAddressLabel returns an option that makes the resolver use the advertised address of the label,
+// falling back to the primary address of instances without it.
+// If not set, the label is read from the env KITEX_ETCD_RESOLVER_ADDRESS_LABEL.
+func WithAddressLabel(label string) Option {
+	return func(cfg *Config) {
+		cfg.AddressLabel = label
+	}
+}
+
+// WithLeaseTTL returns an option that sets the lease TTL in seconds of the registry,
+// which takes precedence over the env KITEX_ETCD_REGISTRY_LEASE_TTL.
+func WithLeaseTTL(ttl int64) Option {
+	return func(cfg *Config) {
+		cfg.LeaseTTL = ttl
+	}
+}
+
+// WithServiceLeaseTTL returns an option that sets the lease TTL in seconds of the service,
+// which takes precedence over WithLeaseTTL.
+func WithServiceLeaseTTL(service string, ttl int64) Option {
+	return func(cfg *Config) {
+		if cfg.ServiceLeaseTTLs == nil {
+			cfg.ServiceLeaseTTLs = make(map[string]int64)
+		}
+		cfg.ServiceLeaseTTLs[service] = ttl
+	}
+}
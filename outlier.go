@@ -0,0 +1,155 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/klog"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const suspectPrefixSuffix = "-suspects"
+
+// OutlierConfig is the config of suspect records, which resolvers write for the instances they find unhealthy.
+// Suspect records are stored under <prefix>-suspects/<service>/<addr>/<reporter> with their own lease.
+type OutlierConfig struct {
+	// The ID of this resolver in suspect records, <hostname>-<pid> by default
+	ReporterID string
+
+	// The TTL in seconds of a suspect record
+	TTL int64
+
+	// The number of reporters flagging an instance before it is down-weighted
+	MinReporters int
+
+	// The factor multiplied to the weight of a down-weighted instance
+	WeightFactor float64
+}
+
+// outlierReporter writes suspect records and down-weights the instances flagged by other resolvers.
+type outlierReporter struct {
+	cfg    OutlierConfig
+	prefix string
+
+	mu sync.Mutex
+	// the time the records written by this resolver expire
+	reported map[string]time.Time
+}
+
+func newOutlierReporter(prefix string, cfg *OutlierConfig) *outlierReporter {
+	if cfg == nil {
+		return nil
+	}
+	c := *cfg
+	if c.ReporterID == "" {
+		hostname, _ := os.Hostname()
+		c.ReporterID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if c.TTL <= 0 {
+		c.TTL = 30
+	}
+	if c.MinReporters <= 0 {
+		c.MinReporters = 2
+	}
+	if c.WeightFactor <= 0 || c.WeightFactor > 1 {
+		c.WeightFactor = 0.1
+	}
+	return &outlierReporter{
+		cfg:      c,
+		prefix:   prefix + suspectPrefixSuffix,
+		reported: make(map[string]time.Time),
+	}
+}
+
+// ReportSuspect writes a suspect record for the instance at addr of the service.
+// It does nothing if the resolver is not created with WithOutlierReport.
+func ReportSuspect(ctx context.Context, r discovery.Resolver, service, addr string) error {
+	er, ok := r.(*etcdResolver)
+	if !ok {
+		panic("invalid resolver type: not etcdResolver")
+	}
+	if er.outlier == nil {
+		return nil
+	}
+	return er.reportSuspect(ctx, service, addr)
+}
+
+func (e *etcdResolver) reportSuspect(ctx context.Context, service, addr string) error {
+	o := e.outlier
+	key := serviceKey(o.prefix, service, addr) + "/" + o.cfg.ReporterID
+	o.mu.Lock()
+	if expire, ok := o.reported[key]; ok && time.Now().Before(expire) {
+		o.mu.Unlock()
+		return nil
+	}
+	o.mu.Unlock()
+
+	resp, err := e.etcdClient.Grant(ctx, o.cfg.TTL)
+	if err != nil {
+		return err
+	}
+	if _, err = e.etcdClient.Put(ctx, key, "", clientv3.WithLease(resp.ID)); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.reported[key] = time.Now().Add(time.Duration(o.cfg.TTL) * time.Second)
+	for k, expire := range o.reported {
+		if time.Now().After(expire) {
+			delete(o.reported, k)
+		}
+	}
+	o.mu.Unlock()
+	klog.Infof("report suspect instance %s of %s", addr, service)
+	return nil
+}
+
+// downWeight reduces the weight of the instances flagged by enough reporters.
+func (e *etcdResolver) downWeight(ctx context.Context, desc string, infos []instanceInfo) []instanceInfo {
+	o := e.outlier
+	prefix := serviceKeyPrefix(o.prefix, desc)
+	kvs, _, err := e.readShared(ctx, prefix, true)
+	if err != nil {
+		klog.Warnf("get suspect records of %s failed with err: %v", desc, err)
+		return infos
+	}
+	reporters := make(map[string]int)
+	for _, kv := range kvs {
+		addr, _, found := strings.Cut(strings.TrimPrefix(string(kv.Key), prefix), "/")
+		if found {
+			reporters[addr]++
+		}
+	}
+	if len(reporters) == 0 {
+		return infos
+	}
+	for i := range infos {
+		if infos[i].Weight <= 0 || reporters[infos[i].Address] < o.cfg.MinReporters {
+			continue
+		}
+		weight := int(float64(infos[i].Weight) * o.cfg.WeightFactor)
+		if weight <= 0 {
+			weight = 1
+		}
+		infos[i].Weight = weight
+	}
+	return infos
+}
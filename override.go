@@ -0,0 +1,120 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const overridePrefixSuffix = "-overrides"
+
+// InstanceOverride patches the registered info of an instance.
+// It is stored as JSON under <prefix>-overrides/<service>/<addr>, so it survives the re-registration of the instance.
+type InstanceOverride struct {
+	// Weight replaces the registered weight if set
+	Weight *int `json:"weight,omitempty"`
+
+	// Tags are merged over the registered tags
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Disabled removes the instance from results
+	Disabled bool `json:"disabled,omitempty"`
+
+	// ExpiresAt makes the override ignored after the time if set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// PutInstanceOverride stores the override of the instance at addr of the service.
+// If ttl > 0, the override is attached to a lease and deleted by etcd after ttl.
+func PutInstanceOverride(ctx context.Context, client *clientv3.Client, prefix, service, addr string, override *InstanceOverride, ttl time.Duration) error {
+	val, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	var opts []clientv3.OpOption
+	if ttl > 0 {
+		resp, err := client.Grant(ctx, int64((ttl+time.Second-1)/time.Second))
+		if err != nil {
+			return err
+		}
+		opts = append(opts, clientv3.WithLease(resp.ID))
+	}
+	_, err = client.Put(ctx, serviceKey(prefix+overridePrefixSuffix, service, addr), string(val), opts...)
+	return err
+}
+
+// DeleteInstanceOverride deletes the override of the instance at addr of the service.
+func DeleteInstanceOverride(ctx context.Context, client *clientv3.Client, prefix, service, addr string) error {
+	_, err := client.Delete(ctx, serviceKey(prefix+overridePrefixSuffix, service, addr))
+	return err
+}
+
+// applyOverrides merges the overrides of the service over the instances.
+func (e *etcdResolver) applyOverrides(ctx context.Context, desc string, infos []instanceInfo) []instanceInfo {
+	prefix := serviceKeyPrefix(e.prefix+overridePrefixSuffix, desc)
+	kvs, _, err := e.readShared(ctx, prefix, true)
+	if err != nil {
+		klog.Warnf("get overrides of %s failed with err: %v", desc, err)
+		return infos
+	}
+	if len(kvs) == 0 {
+		return infos
+	}
+	now := time.Now()
+	overrides := make(map[string]*InstanceOverride, len(kvs))
+	for _, kv := range kvs {
+		var override InstanceOverride
+		if err = json.Unmarshal(kv.Value, &override); err != nil {
+			klog.Warnf("fail to unmarshal override with err: %v, ignore key: %v", err, string(kv.Key))
+			continue
+		}
+		if override.ExpiresAt != nil && now.After(*override.ExpiresAt) {
+			continue
+		}
+		overrides[string(kv.Key[len(prefix):])] = &override
+	}
+
+	res := make([]instanceInfo, 0, len(infos))
+	for _, info := range infos {
+		override, ok := overrides[info.Address]
+		if !ok {
+			res = append(res, info)
+			continue
+		}
+		if override.Disabled {
+			continue
+		}
+		if override.Weight != nil {
+			info.Weight = *override.Weight
+		}
+		if len(override.Tags) > 0 {
+			tags := make(map[string]string, len(info.Tags)+len(override.Tags))
+			for k, v := range info.Tags {
+				tags[k] = v
+			}
+			for k, v := range override.Tags {
+				tags[k] = v
+			}
+			info.Tags = tags
+		}
+		res = append(res, info)
+	}
+	return res
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// maxPreloadOps is the default limit of operations in one etcd txn
+	maxPreloadOps = 128
+	// preloadTTL is how long preloaded instances can be used by Resolve
+	preloadTTL = time.Minute
+)
+
+// Preload reads the instances of the given services in as few etcd round trips as possible,
+// so that the first Resolve of each service does not need to read etcd.
+// Services are read in txns of at most 128 services each. If the page size is set by WithResolvePageSize,
+// which a txn can not honour, services are read one by one page by page instead.
+func Preload(ctx context.Context, r discovery.Resolver, services ...string) error {
+	er, ok := r.(*etcdResolver)
+	if !ok {
+		panic("invalid resolver type: not etcdResolver")
+	}
+	for len(services) > 0 {
+		n := len(services)
+		if n > maxPreloadOps {
+			n = maxPreloadOps
+		}
+		if err := er.preload(ctx, services[:n]); err != nil {
+			return err
+		}
+		services = services[n:]
+	}
+	return nil
+}
+
+func (e *etcdResolver) preload(ctx context.Context, services []string) error {
+	if e.pageSize > 0 {
+		return e.preloadPages(ctx, services)
+	}
+	ops := make([]clientv3.Op, 0, len(services))
+	for _, desc := range services {
+		opts := []clientv3.OpOption{clientv3.WithPrefix()}
+		if e.serializable {
+			opts = append(opts, clientv3.WithSerializable())
+		}
+		ops = append(ops, clientv3.OpGet(serviceKeyPrefix(e.prefix, desc), opts...))
+	}
+	resp, err := e.etcdClient.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return err
+	}
+	rev := resp.Header.Revision
+	e.observeRevision(rev)
+	now := time.Now()
+	for i, r := range resp.Responses {
+		e.preloaded.Store(services[i], &serviceRead{
+			kvs:      r.GetResponseRange().GetKvs(),
+			revision: rev,
+			readAt:   now,
+		})
+	}
+	return nil
+}
+
+// preloadPages reads the services one by one, each page by page at the revision of its first page.
+func (e *etcdResolver) preloadPages(ctx context.Context, services []string) error {
+	for _, desc := range services {
+		kvs, rev, err := e.get(ctx, serviceKeyPrefix(e.prefix, desc), true)
+		if err != nil {
+			return err
+		}
+		e.preloaded.Store(desc, &serviceRead{
+			kvs:      kvs,
+			revision: rev,
+			readAt:   time.Now(),
+		})
+	}
+	return nil
+}
+
+// takePreloaded returns the preloaded keys of the service, each preloaded entry is used only once.
+func (e *etcdResolver) takePreloaded(desc string) ([]*mvccpb.KeyValue, int64, bool) {
+	v, ok := e.preloaded.LoadAndDelete(desc)
+	if !ok {
+		return nil, 0, false
+	}
+	entry := v.(*serviceRead)
+	if time.Since(entry.readAt) > preloadTTL {
+		return nil, 0, false
+	}
+	return entry.kvs, entry.revision, true
+}
@@ -0,0 +1,215 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/cloudwego/kitex/pkg/utils"
+)
+
+// ProbeFunc checks whether the instance at addr is healthy.
+type ProbeFunc func(ctx context.Context, addr net.Addr) error
+
+// ProbeConfig is the config of active health probing of resolved instances.
+type ProbeConfig struct {
+	// The interval between two probes of an instance
+	Interval time.Duration
+
+	// The timeout of a probe
+	Timeout time.Duration
+
+	// The number of consecutive failed probes before an instance is removed from results
+	FailureThreshold int
+
+	// The number of consecutive successful probes before a removed instance is added back
+	SuccessThreshold int
+
+	// The probe, TCPProbe by default
+	Probe ProbeFunc
+}
+
+// TCPProbe checks whether a tcp connection can be established to addr.
+func TCPProbe(ctx context.Context, addr net.Addr) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, addr.Network(), addr.String())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// prober probes the resolved instances in background and filters out the unhealthy ones.
+type prober struct {
+	cfg ProbeConfig
+
+	mu      sync.Mutex
+	targets map[string]*probeTarget
+	// onUnhealthy is called for each service of an instance when the instance becomes unhealthy,
+	// with the address the instance is registered with in the service
+	onUnhealthy func(service, addr string)
+}
+
+type probeTarget struct {
+	addr net.Addr
+	// the address the instance is registered with in each service, which differs from addr if it is translated
+	services  map[string]string
+	healthy   bool
+	failures  int
+	successes int
+	lastSeen  time.Time
+}
+
+func newProber(cfg *ProbeConfig) *prober {
+	if cfg == nil {
+		return nil
+	}
+	c := *cfg
+	if c.Interval <= 0 {
+		c.Interval = 5 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 3
+	}
+	if c.SuccessThreshold <= 0 {
+		c.SuccessThreshold = 1
+	}
+	if c.Probe == nil {
+		c.Probe = TCPProbe
+	}
+	return &prober{
+		cfg:     c,
+		targets: make(map[string]*probeTarget),
+	}
+}
+
+func probeKey(network, address string) string {
+	return network + "|" + address
+}
+
+// filter records the instances of the service to probe and returns the healthy ones.
+// If none of the instances is healthy, all of them are returned.
+func (p *prober) filter(desc string, infos []instanceInfo) []instanceInfo {
+	now := time.Now()
+	healthy := make([]instanceInfo, 0, len(infos))
+	p.mu.Lock()
+	for _, info := range infos {
+		key := probeKey(info.Network, info.Address)
+		target, ok := p.targets[key]
+		if !ok {
+			target = &probeTarget{
+				addr:     utils.NewNetAddr(info.Network, info.Address),
+				services: make(map[string]string),
+				healthy:  true,
+			}
+			p.targets[key] = target
+		}
+		registered := info.registeredAddress
+		if registered == "" {
+			registered = info.Address
+		}
+		target.services[desc] = registered
+		target.lastSeen = now
+		if target.healthy {
+			healthy = append(healthy, info)
+		}
+	}
+	p.mu.Unlock()
+	if len(healthy) == 0 && len(infos) > 0 {
+		klog.Warnf("all %d instances are unhealthy, ignore health probe", len(infos))
+		return infos
+	}
+	return healthy
+}
+
+// run probes the instances every interval until ctx is done.
+func (p *prober) run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.probeAll(ctx)
+		}
+	}
+}
+
+func (p *prober) probeAll(ctx context.Context) {
+	// instances which are not resolved for a while are not probed anymore
+	expire := time.Now().Add(-10 * p.cfg.Interval)
+	var targets []*probeTarget
+	p.mu.Lock()
+	for key, target := range p.targets {
+		if target.lastSeen.Before(expire) {
+			delete(p.targets, key)
+			continue
+		}
+		targets = append(targets, target)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target *probeTarget) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+			err := p.cfg.Probe(pctx, target.addr)
+			cancel()
+			if ctx.Err() == nil {
+				p.report(target, err)
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+func (p *prober) report(target *probeTarget, err error) {
+	services := make(map[string]string)
+	p.mu.Lock()
+	if err != nil {
+		target.failures++
+		target.successes = 0
+		if target.healthy && target.failures >= p.cfg.FailureThreshold {
+			target.healthy = false
+			klog.Warnf("instance %s is unhealthy and removed, err: %v", target.addr, err)
+			for service, addr := range target.services {
+				services[service] = addr
+			}
+		}
+	} else {
+		target.successes++
+		target.failures = 0
+		if !target.healthy && target.successes >= p.cfg.SuccessThreshold {
+			target.healthy = true
+			klog.Infof("instance %s recovers and is added back", target.addr)
+		}
+	}
+	p.mu.Unlock()
+	if p.onUnhealthy != nil {
+		for service, addr := range services {
+			p.onUnhealthy(service, addr)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber(t *testing.T) {
+	var failing atomic.Value
+	failing.Store("127.0.0.1:8001")
+	p := newProber(&ProbeConfig{
+		FailureThreshold: 2,
+		Probe: func(ctx context.Context, addr net.Addr) error {
+			if addr.String() == failing.Load().(string) {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+	})
+	a := instanceInfo{Network: "tcp", Address: "127.0.0.1:8001", Weight: 10}
+	b := instanceInfo{Network: "tcp", Address: "127.0.0.1:8002", Weight: 10}
+	infos := []instanceInfo{a, b}
+
+	require.Equal(t, infos, p.filter(serviceName, infos))
+	p.probeAll(context.Background())
+	require.Equal(t, infos, p.filter(serviceName, infos))
+	p.probeAll(context.Background())
+	require.Equal(t, []instanceInfo{b}, p.filter(serviceName, infos))
+
+	// all instances are unhealthy
+	require.Equal(t, []instanceInfo{a}, p.filter(serviceName, []instanceInfo{a}))
+
+	// recover
+	failing.Store("")
+	p.probeAll(context.Background())
+	require.Equal(t, infos, p.filter(serviceName, infos))
+}
+
+func TestTCPProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := ln.Addr()
+	require.Nil(t, TCPProbe(context.Background(), addr))
+	ln.Close()
+	require.NotNil(t, TCPProbe(context.Background(), addr))
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/event"
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// ProtectionEventName is the name of the event dispatched when the resolver keeps
+// the last instances of a service because too many instances disappeared at once.
+const ProtectionEventName = "etcd_resolver_protection"
+
+const defaultProtectionMaxHold = 5 * time.Minute
+
+// protection keeps the last good instances of each service to guard against
+// an emptied etcd or a mass deregistration.
+type protection struct {
+	threshold float64
+	// the longest time the last good instances are kept, after which a smaller result is accepted
+	maxHold time.Duration
+
+	mu   sync.Mutex
+	last map[string][]discovery.Instance
+	// the number of instances registered in etcd when the last good instances were recorded
+	registered map[string]int
+	// the time protection started for each service
+	since map[string]time.Time
+}
+
+func newProtection(threshold float64, maxHold time.Duration) *protection {
+	if threshold <= 0 {
+		return nil
+	}
+	if threshold > 1 {
+		threshold = 1
+	}
+	if maxHold <= 0 {
+		maxHold = defaultProtectionMaxHold
+	}
+	return &protection{
+		threshold:  threshold,
+		maxHold:    maxHold,
+		last:       make(map[string][]discovery.Instance),
+		registered: make(map[string]int),
+		since:      make(map[string]time.Time),
+	}
+}
+
+// protect returns the last good instances of the service if the number of instances registered in etcd
+// is much smaller than when they were recorded, otherwise eps is recorded as the last good instances and returned.
+// The numbers are counted before instances are filtered, e.g. by overrides, health probe or zone, so that filtering is never
+// taken as a mass deregistration. The last good instances are kept for at most maxHold,
+// so that a deliberate scale-down is accepted in the end.
+func (e *etcdResolver) protect(desc string, registered int, eps []discovery.Instance) []discovery.Instance {
+	p := e.protection
+	now := time.Now()
+	p.mu.Lock()
+	prev, prevRegistered := p.last[desc], p.registered[desc]
+	if len(prev) > 0 && float64(registered) < float64(prevRegistered)*p.threshold {
+		since, ok := p.since[desc]
+		if !ok {
+			since = now
+			p.since[desc] = now
+		}
+		if now.Sub(since) < p.maxHold {
+			p.mu.Unlock()
+			detail := fmt.Sprintf("instances of %s dropped from %d to %d, keep the last %d instances",
+				desc, prevRegistered, registered, len(prev))
+			klog.Warnf("etcd resolver protection: %s", detail)
+			e.dispatchEvent(ProtectionEventName, detail)
+			return prev
+		}
+		klog.Warnf("etcd resolver protection: instances of %s stayed at %d for %v, accept them", desc, registered, p.maxHold)
+	}
+	delete(p.since, desc)
+	if len(eps) > 0 {
+		p.last[desc] = eps
+		p.registered[desc] = registered
+	} else {
+		delete(p.last, desc)
+		delete(p.registered, desc)
+	}
+	p.mu.Unlock()
+	return eps
+}
+
+func (e *etcdResolver) dispatchEvent(name, detail string) {
+	if e.eventBus == nil {
+		return
+	}
+	e.eventBus.Dispatch(&event.Event{
+		Name:   name,
+		Time:   time.Now(),
+		Detail: detail,
+	})
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/event"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestEtcdResolverProtection(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+	defer teardownEmbedEtcd(s)
+
+	bus := event.NewEventBus()
+	events := make(chan *event.Event, 1)
+	bus.Watch(ProtectionEventName, func(ev *event.Event) {
+		events <- ev
+	})
+	rs, err := NewEtcdResolver([]string{endpoint}, WithProtectionThreshold(0.5), WithEventBus(bus))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	var infoList []registry.Info
+	for i := 0; i < 4; i++ {
+		info := registry.Info{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", fmt.Sprintf("127.0.0.1:%d", 8000+i)),
+			Weight:      10,
+		}
+		infoList = append(infoList, info)
+		putInstance(t, cli, &info)
+	}
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 4)
+
+	// a small drop is accepted
+	deleteInstance(t, cli, &infoList[0])
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 3)
+
+	// more than half of the instances disappear at once
+	deleteInstance(t, cli, &infoList[1])
+	deleteInstance(t, cli, &infoList[2])
+	deleteInstance(t, cli, &infoList[3])
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 3)
+	ev := <-events
+	require.Equal(t, ProtectionEventName, ev.Name)
+}
+
+func TestEtcdResolverProtectionMaxHold(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+	defer teardownEmbedEtcd(s)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithProtectionThreshold(0.5), WithProtectionMaxHold(200*time.Millisecond))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	var infoList []registry.Info
+	for i := 0; i < 10; i++ {
+		info := registry.Info{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", fmt.Sprintf("127.0.0.1:%d", 8000+i)),
+			Weight:      10,
+		}
+		infoList = append(infoList, info)
+		putInstance(t, cli, &info)
+	}
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 10)
+
+	// scale down from 10 to 3 replicas
+	for i := 3; i < 10; i++ {
+		deleteInstance(t, cli, &infoList[i])
+	}
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 10)
+
+	// the scale-down is accepted after the max hold
+	time.Sleep(200 * time.Millisecond)
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 3)
+
+	// and protection compares with the accepted result
+	deleteInstance(t, cli, &infoList[0])
+	deleteInstance(t, cli, &infoList[1])
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 3)
+}
+
+func TestEtcdResolverProtectionWithHealthProbe(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+	defer teardownEmbedEtcd(s)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithProtectionThreshold(0.5), WithHealthProbe(ProbeConfig{
+		Interval:         time.Hour,
+		FailureThreshold: 1,
+		Probe: func(ctx context.Context, addr net.Addr) error {
+			if addr.String() != "127.0.0.1:8000" {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+	}))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	for i := 0; i < 4; i++ {
+		putInstance(t, cli, &registry.Info{
+			ServiceName: serviceName,
+			Addr:        utils.NewNetAddr("tcp", fmt.Sprintf("127.0.0.1:%d", 8000+i)),
+			Weight:      10,
+		})
+	}
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 4)
+
+	// unhealthy instances are still registered, so removing them is not protected against
+	rs.(*etcdResolver).prober.probeAll(context.Background())
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, []discovery.Instance{discovery.NewInstance("tcp", "127.0.0.1:8000", 10, nil)}, result.Instances)
+}
+
+func TestEtcdResolverProtectionWithZoneFallback(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+	defer teardownEmbedEtcd(s)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithProtectionThreshold(0.5),
+		WithZoneAware("az1"), WithZoneFallbackThreshold(2))
+	require.Nil(t, err)
+	cli := rs.(*etcdResolver).etcdClient
+
+	put := func(port int, zone string) {
+		addr := fmt.Sprintf("127.0.0.1:%d", port)
+		val := fmt.Sprintf(`{"network":"tcp","address":%q,"weight":10,"tags":{"zone":%q}}`, addr, zone)
+		_, err := cli.Put(context.TODO(), serviceKey("kitex/registry-etcd", serviceName, addr), val)
+		require.Nil(t, err)
+	}
+	put(8000, "az1")
+	for i := 1; i <= 4; i++ {
+		put(8000+i, "az2")
+	}
+	// one same-zone instance falls back to other zones
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 5)
+
+	// the zone recovers, and the result shrinks to the same-zone instances without being protected against
+	put(8005, "az1")
+	result, err = rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 2)
+}
+
+func putInstance(t *testing.T, cli *clientv3.Client, info *registry.Info) {
+	val := fmt.Sprintf(`{"network":%q,"address":%q,"weight":%d}`, info.Addr.Network(), info.Addr.String(), info.Weight)
+	_, err := cli.Put(context.TODO(), serviceKey("kitex/registry-etcd", info.ServiceName, info.Addr.String()), val)
+	require.Nil(t, err)
+}
+
+func deleteInstance(t *testing.T, cli *clientv3.Client, info *registry.Info) {
+	_, err := cli.Delete(context.TODO(), serviceKey("kitex/registry-etcd", info.ServiceName, info.Addr.String()))
+	require.Nil(t, err)
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// resolveOverrideKey is the env of local overrides, formatted as "svc=127.0.0.1:8888,svc=127.0.0.1:8889,other=[::1]:9999"
+const resolveOverrideKey = "KITEX_ETCD_RESOLVE_OVERRIDE"
+
+// newResolveOverrides merges the overrides of the env over the overrides of options.
+// A service in the env replaces all its addresses set by options.
+func newResolveOverrides(opts map[string][]string) map[string][]string {
+	overrides := make(map[string][]string, len(opts))
+	for service, addrs := range opts {
+		for _, addr := range addrs {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				klog.Warnf("invalid resolve override %s=%s with err: %v", service, addr, err)
+				continue
+			}
+			overrides[service] = append(overrides[service], addr)
+		}
+	}
+	str, ok := os.LookupEnv(resolveOverrideKey)
+	if !ok || str == "" {
+		return overrides
+	}
+	fromEnv := make(map[string][]string)
+	for _, entry := range strings.Split(str, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		service, addr, found := strings.Cut(entry, "=")
+		if !found || service == "" {
+			klog.Warnf("invalid %s entry: %s", resolveOverrideKey, entry)
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			klog.Warnf("invalid %s entry: %s, err: %v", resolveOverrideKey, entry, err)
+			continue
+		}
+		fromEnv[service] = append(fromEnv[service], addr)
+	}
+	for service, addrs := range fromEnv {
+		overrides[service] = addrs
+	}
+	return overrides
+}
+
+// resolveOverride returns the local instances of the service if it is overridden.
+func (e *etcdResolver) resolveOverride(desc string) ([]discovery.Instance, bool) {
+	addrs, ok := e.resolveOverrides[desc]
+	if !ok || len(addrs) == 0 {
+		return nil, false
+	}
+	if _, logged := e.loggedOverrides.LoadOrStore(desc, struct{}{}); !logged {
+		klog.Warnf("RESOLVE OVERRIDE: service %s is resolved to %v locally instead of etcd", desc, addrs)
+	}
+	eps := make([]discovery.Instance, 0, len(addrs))
+	for _, addr := range addrs {
+		eps = append(eps, discovery.NewInstance("tcp", addr, e.defaultWeight, nil))
+	}
+	return eps, true
+}
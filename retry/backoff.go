@@ -0,0 +1,204 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decides the delays of one retry loop.
+// It keeps the state of the loop, so it is not shared between loops.
+type Backoff interface {
+	// Next returns the delay before the next retry after a failure, and false if the loop should give up.
+	Next() (time.Duration, bool)
+
+	// Reset is called after a success, so that the next failure starts over.
+	Reset()
+}
+
+// Policy creates a Backoff for each retry loop.
+type Policy interface {
+	NewBackoff() Backoff
+}
+
+// Jitter is how the retry delay is randomized, so that servers retrying at the same time spread out.
+type Jitter int
+
+const (
+	// NoJitter uses the delay as is
+	NoJitter Jitter = iota
+	// FullJitter picks a random delay between 0 and the delay
+	FullJitter
+	// DecorrelatedJitter picks a random delay between RetryDelay and Multiplier (3 if not greater than 1) times the previous delay
+	DecorrelatedJitter
+)
+
+// FixedPolicy retries with the same delay.
+type FixedPolicy struct {
+	Delay time.Duration
+
+	// The maximum number of failures before giving up, 0 means retry forever
+	MaxAttemptTimes uint
+}
+
+// NewBackoff implements the Policy interface.
+func (p *FixedPolicy) NewBackoff() Backoff {
+	return &fixedBackoff{policy: *p}
+}
+
+type fixedBackoff struct {
+	policy      FixedPolicy
+	failedTimes uint
+}
+
+func (b *fixedBackoff) Next() (time.Duration, bool) {
+	b.failedTimes++
+	if b.policy.MaxAttemptTimes != 0 && b.failedTimes >= b.policy.MaxAttemptTimes {
+		return 0, false
+	}
+	return b.policy.Delay, true
+}
+
+func (b *fixedBackoff) Reset() {
+	b.failedTimes = 0
+}
+
+// ExponentialPolicy retries with a delay multiplied after each failure.
+type ExponentialPolicy struct {
+	InitialDelay time.Duration
+
+	// The factor multiplied to the delay after each failure, the delay is fixed if it is not greater than 1,
+	// except with DecorrelatedJitter, which uses 3 instead
+	Multiplier float64
+
+	// The maximum delay, no limit if it is 0
+	MaxDelay time.Duration
+
+	Jitter Jitter
+
+	// The maximum number of failures before giving up, 0 means retry forever
+	MaxAttemptTimes uint
+}
+
+// NewBackoff implements the Policy interface.
+func (p *ExponentialPolicy) NewBackoff() Backoff {
+	return &exponentialBackoff{policy: *p}
+}
+
+// nextDelay returns the delay after failedTimes consecutive failures, prev is the last delay, 0 if there is none.
+func (p *ExponentialPolicy) nextDelay(failedTimes uint, prev time.Duration) time.Duration {
+	if p.Jitter == DecorrelatedJitter {
+		multiplier := p.Multiplier
+		if multiplier <= 1 {
+			multiplier = 3
+		}
+		upper := time.Duration(math.MaxInt64)
+		if u := float64(prev) * multiplier; u < math.MaxInt64 {
+			upper = time.Duration(u)
+		}
+		delay := p.InitialDelay
+		if upper > p.InitialDelay {
+			delay += time.Duration(rand.Int63n(int64(upper - p.InitialDelay)))
+		}
+		return p.capDelay(delay)
+	}
+
+	delay := p.InitialDelay
+	if p.Multiplier > 1 && failedTimes > 1 {
+		d := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(failedTimes-1))
+		if d >= math.MaxInt64 {
+			delay = math.MaxInt64
+		} else {
+			delay = time.Duration(d)
+		}
+	}
+	delay = p.capDelay(delay)
+	if p.Jitter == FullJitter && delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+	return delay
+}
+
+func (p *ExponentialPolicy) capDelay(delay time.Duration) time.Duration {
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+type exponentialBackoff struct {
+	policy      ExponentialPolicy
+	failedTimes uint
+	prev        time.Duration
+}
+
+func (b *exponentialBackoff) Next() (time.Duration, bool) {
+	b.failedTimes++
+	if b.policy.MaxAttemptTimes != 0 && b.failedTimes >= b.policy.MaxAttemptTimes {
+		return 0, false
+	}
+	b.prev = b.policy.nextDelay(b.failedTimes, b.prev)
+	return b.prev, true
+}
+
+func (b *exponentialBackoff) Reset() {
+	b.failedTimes = 0
+	b.prev = 0
+}
+
+// DeadlinePolicy retries with the delays of Policy until Timeout has passed since the first failure.
+type DeadlinePolicy struct {
+	// The policy deciding retry delays, the default retry delay is used if it is nil
+	Policy  Policy
+	Timeout time.Duration
+}
+
+// NewBackoff implements the Policy interface.
+func (p *DeadlinePolicy) NewBackoff() Backoff {
+	policy := p.Policy
+	if policy == nil {
+		policy = &FixedPolicy{Delay: NewRetryConfig().RetryDelay}
+	}
+	return &deadlineBackoff{backoff: policy.NewBackoff(), timeout: p.Timeout}
+}
+
+type deadlineBackoff struct {
+	backoff  Backoff
+	timeout  time.Duration
+	deadline time.Time
+}
+
+func (b *deadlineBackoff) Next() (time.Duration, bool) {
+	now := time.Now()
+	if b.deadline.IsZero() {
+		b.deadline = now.Add(b.timeout)
+	}
+	delay, ok := b.backoff.Next()
+	if !ok || !now.Before(b.deadline) {
+		return 0, false
+	}
+	// the last retry happens at the deadline
+	if remaining := b.deadline.Sub(now); delay > remaining {
+		delay = remaining
+	}
+	return delay, true
+}
+
+func (b *deadlineBackoff) Reset() {
+	b.backoff.Reset()
+	b.deadline = time.Time{}
+}
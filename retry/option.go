@@ -41,3 +41,31 @@ func WithRetryDelay(retryDelay time.Duration) Option {
 		o.RetryDelay = retryDelay
 	}}
 }
+
+// WithMultiplier sets Multiplier
+func WithMultiplier(multiplier float64) Option {
+	return Option{F: func(o *Config) {
+		o.Multiplier = multiplier
+	}}
+}
+
+// WithMaxDelay sets MaxDelay
+func WithMaxDelay(maxDelay time.Duration) Option {
+	return Option{F: func(o *Config) {
+		o.MaxDelay = maxDelay
+	}}
+}
+
+// WithJitter sets Jitter
+func WithJitter(jitter Jitter) Option {
+	return Option{F: func(o *Config) {
+		o.Jitter = jitter
+	}}
+}
+
+// WithPolicy sets Policy
+func WithPolicy(policy Policy) Option {
+	return Option{F: func(o *Config) {
+		o.Policy = policy
+	}}
+}
@@ -25,6 +25,19 @@ type Config struct {
 
 	// The retry delay time
 	RetryDelay time.Duration
+
+	// The factor multiplied to the retry delay after each failure, the delay is fixed if it is not greater than 1,
+	// except with DecorrelatedJitter, which uses 3 instead
+	Multiplier float64
+
+	// The maximum retry delay time, no limit if it is 0
+	MaxDelay time.Duration
+
+	// The jitter of the retry delay
+	Jitter Jitter
+
+	// The policy deciding retry delays, which replaces MaxAttemptTimes, RetryDelay, Multiplier, MaxDelay and Jitter if set
+	Policy Policy
 }
 
 func (o *Config) Apply(opts []Option) {
@@ -44,3 +57,25 @@ func NewRetryConfig(opts ...Option) *Config {
 
 	return retryConfig
 }
+
+// NewBackoff implements the Policy interface.
+// It uses Policy if set, otherwise a fixed or exponential backoff built from the config.
+func (o *Config) NewBackoff() Backoff {
+	if o.Policy != nil {
+		return o.Policy.NewBackoff()
+	}
+	if o.Multiplier <= 1 && o.Jitter == NoJitter {
+		return (&FixedPolicy{Delay: o.RetryDelay, MaxAttemptTimes: o.MaxAttemptTimes}).NewBackoff()
+	}
+	return o.exponential().NewBackoff()
+}
+
+func (o *Config) exponential() *ExponentialPolicy {
+	return &ExponentialPolicy{
+		InitialDelay:    o.RetryDelay,
+		Multiplier:      o.Multiplier,
+		MaxDelay:        o.MaxDelay,
+		Jitter:          o.Jitter,
+		MaxAttemptTimes: o.MaxAttemptTimes,
+	}
+}
@@ -38,3 +38,124 @@ func TestRetryCustomConfig(t *testing.T) {
 	assert.Equal(t, 20*time.Second, retryConfig.ObserveDelay)
 	assert.Equal(t, 5*time.Second, retryConfig.RetryDelay)
 }
+
+func TestRetryFixedDelay(t *testing.T) {
+	retryConfig := NewRetryConfig()
+	assert.Equal(t, 10*time.Second, retryConfig.exponential().nextDelay(1, 0))
+	assert.Equal(t, 10*time.Second, retryConfig.exponential().nextDelay(5, 10*time.Second))
+}
+
+func TestRetryExponentialDelay(t *testing.T) {
+	retryConfig := NewRetryConfig(
+		WithRetryDelay(time.Second),
+		WithMultiplier(2),
+		WithMaxDelay(10*time.Second),
+	)
+	assert.Equal(t, time.Second, retryConfig.exponential().nextDelay(1, 0))
+	assert.Equal(t, 2*time.Second, retryConfig.exponential().nextDelay(2, 0))
+	assert.Equal(t, 8*time.Second, retryConfig.exponential().nextDelay(4, 0))
+	assert.Equal(t, 10*time.Second, retryConfig.exponential().nextDelay(5, 0))
+	assert.Equal(t, 10*time.Second, retryConfig.exponential().nextDelay(1000, 0))
+
+	retryConfig.Jitter = FullJitter
+	for i := 0; i < 100; i++ {
+		delay := retryConfig.exponential().nextDelay(3, 0)
+		assert.True(t, delay >= 0 && delay <= 4*time.Second)
+	}
+}
+
+func TestRetryDecorrelatedJitter(t *testing.T) {
+	retryConfig := NewRetryConfig(
+		WithRetryDelay(time.Second),
+		WithMaxDelay(10*time.Second),
+		WithJitter(DecorrelatedJitter),
+	)
+	assert.Equal(t, time.Second, retryConfig.exponential().nextDelay(1, 0))
+	var delay time.Duration
+	for i := uint(1); i <= 100; i++ {
+		prev := delay
+		delay = retryConfig.exponential().nextDelay(i, prev)
+		assert.True(t, delay >= time.Second && delay <= 10*time.Second)
+		if prev > 0 {
+			assert.True(t, delay <= 3*prev)
+		}
+	}
+}
+
+func TestFixedBackoff(t *testing.T) {
+	backoff := NewRetryConfig(WithMaxAttemptTimes(3)).NewBackoff()
+	for i := 0; i < 2; i++ {
+		delay, ok := backoff.Next()
+		assert.True(t, ok)
+		assert.Equal(t, 10*time.Second, delay)
+	}
+	_, ok := backoff.Next()
+	assert.False(t, ok)
+
+	backoff.Reset()
+	_, ok = backoff.Next()
+	assert.True(t, ok)
+
+	// retry forever
+	backoff = NewRetryConfig(WithMaxAttemptTimes(0)).NewBackoff()
+	for i := 0; i < 100; i++ {
+		_, ok = backoff.Next()
+		assert.True(t, ok)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := NewRetryConfig(
+		WithMaxAttemptTimes(0),
+		WithRetryDelay(time.Second),
+		WithMultiplier(2),
+		WithMaxDelay(5*time.Second),
+	).NewBackoff()
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		delay, ok := backoff.Next()
+		assert.True(t, ok)
+		assert.Equal(t, expected, delay)
+	}
+	backoff.Reset()
+	delay, _ := backoff.Next()
+	assert.Equal(t, time.Second, delay)
+}
+
+func TestDeadlineBackoff(t *testing.T) {
+	backoff := (&DeadlinePolicy{
+		Policy:  &FixedPolicy{Delay: 30 * time.Millisecond},
+		Timeout: 50 * time.Millisecond,
+	}).NewBackoff()
+	delay, ok := backoff.Next()
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Millisecond, delay)
+	time.Sleep(delay)
+	// the delay is cut to the deadline
+	delay, ok = backoff.Next()
+	assert.True(t, ok)
+	assert.True(t, delay <= 20*time.Millisecond)
+	time.Sleep(delay)
+	_, ok = backoff.Next()
+	assert.False(t, ok)
+
+	backoff.Reset()
+	_, ok = backoff.Next()
+	assert.True(t, ok)
+}
+
+func TestDeadlineBackoffWithoutPolicy(t *testing.T) {
+	backoff := (&DeadlinePolicy{Timeout: time.Minute}).NewBackoff()
+	delay, ok := backoff.Next()
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Second, delay)
+}
+
+func TestRetryConfigWithPolicy(t *testing.T) {
+	retryConfig := NewRetryConfig(WithPolicy(&FixedPolicy{Delay: time.Second, MaxAttemptTimes: 2}))
+	backoff := retryConfig.NewBackoff()
+	delay, ok := backoff.Next()
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, delay)
+	_, ok = backoff.Next()
+	assert.False(t, ok)
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+const (
+	snapshotVersion = 1
+	snapshotSuffix  = ".json"
+)
+
+// snapshotFile is the content of the snapshot file of a service.
+type snapshotFile struct {
+	Version   int            `json:"version"`
+	Service   string         `json:"service"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Instances []instanceInfo `json:"instances"`
+}
+
+// snapshotStore persists the latest instances of each service to disk,
+// so that they can be used when etcd is unreachable at startup.
+type snapshotStore struct {
+	dir string
+
+	mu sync.Mutex
+	// the instances loaded from disk, dropped after the first successful read of the service
+	loaded  map[string][]discovery.Instance
+	written map[string][]byte
+}
+
+func newSnapshotStore(dir string) (*snapshotStore, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &snapshotStore{
+		dir:     dir,
+		loaded:  make(map[string][]discovery.Instance),
+		written: make(map[string][]byte),
+	}
+	s.load()
+	return s, nil
+}
+
+// load reads all snapshot files in the directory, invalid files are ignored.
+func (s *snapshotStore) load() {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		klog.Warnf("read snapshot dir %s failed with err: %v", s.dir, err)
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), snapshotSuffix) {
+			continue
+		}
+		path := filepath.Join(s.dir, f.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.Warnf("read snapshot %s failed with err: %v", path, err)
+			continue
+		}
+		var snapshot snapshotFile
+		if err = json.Unmarshal(data, &snapshot); err != nil {
+			klog.Warnf("fail to unmarshal snapshot %s with err: %v", path, err)
+			continue
+		}
+		if snapshot.Version != snapshotVersion {
+			klog.Warnf("ignore snapshot %s with unsupported version %d", path, snapshot.Version)
+			continue
+		}
+		eps := make([]discovery.Instance, 0, len(snapshot.Instances))
+		for _, info := range snapshot.Instances {
+			eps = append(eps, discovery.NewInstance(info.Network, info.Address, info.Weight, info.Tags))
+		}
+		s.loaded[snapshot.Service] = eps
+	}
+}
+
+// get returns the instances of the service loaded from disk, if the service has not been read from etcd yet.
+func (s *snapshotStore) get(desc string) ([]discovery.Instance, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	eps, ok := s.loaded[desc]
+	return eps, ok && len(eps) > 0
+}
+
+// save writes the instances of the service to its snapshot file if they have changed.
+// The snapshot is only a startup fallback, so the loaded instances of the service are no longer served after it.
+func (s *snapshotStore) save(desc string, eps []discovery.Instance) {
+	s.mu.Lock()
+	delete(s.loaded, desc)
+	s.mu.Unlock()
+	if len(eps) == 0 {
+		return
+	}
+	infos := make([]instanceInfo, 0, len(eps))
+	for _, ins := range eps {
+		infos = append(infos, toInstanceInfo(ins))
+	}
+	content, err := json.Marshal(infos)
+	if err != nil {
+		klog.Warnf("fail to marshal snapshot of %s with err: %v", desc, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(s.written[desc], content) {
+		return
+	}
+	data, err := json.Marshal(&snapshotFile{
+		Version:   snapshotVersion,
+		Service:   desc,
+		UpdatedAt: time.Now(),
+		Instances: infos,
+	})
+	if err != nil {
+		klog.Warnf("fail to marshal snapshot of %s with err: %v", desc, err)
+		return
+	}
+	if err = writeFileAtomic(filepath.Join(s.dir, url.PathEscape(desc)+snapshotSuffix), data); err != nil {
+		klog.Warnf("write snapshot of %s failed with err: %v", desc, err)
+		return
+	}
+	s.written[desc] = content
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path,
+// so that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEtcdResolverSnapshot(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+	dir := t.TempDir()
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithSnapshotDir(dir))
+	require.Nil(t, err)
+	info := registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	putInstance(t, rs.(*etcdResolver).etcdClient, &info)
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.FileExists(t, filepath.Join(dir, serviceName+snapshotSuffix))
+
+	// an unsupported snapshot is ignored
+	err = os.WriteFile(filepath.Join(dir, "other"+snapshotSuffix), []byte(`{"version":100,"service":"other"}`), 0o644)
+	require.Nil(t, err)
+
+	// a new resolver starts while etcd is unreachable
+	teardownEmbedEtcd(s)
+	rs, err = NewEtcdResolver([]string{endpoint}, WithSnapshotDir(dir))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	snapshotResult, err := rs.Resolve(ctx, serviceName)
+	require.Nil(t, err)
+	require.Equal(t, result, snapshotResult)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = rs.Resolve(ctx, "other")
+	require.NotNil(t, err)
+}
+
+func TestEtcdResolverSnapshotOnlyAtStartup(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, serviceName+snapshotSuffix),
+		[]byte(`{"version":1,"service":"`+serviceName+`","instances":[{"network":"tcp","address":"127.0.0.1:9999","weight":10}]}`), 0o644)
+	require.Nil(t, err)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithSnapshotDir(dir))
+	require.Nil(t, err)
+	info := registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	putInstance(t, rs.(*etcdResolver).etcdClient, &info)
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1:8888", result.Instances[0].Address().String())
+
+	// the snapshot is not served after the service has been read from etcd
+	teardownEmbedEtcd(s)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = rs.Resolve(ctx, serviceName)
+	require.NotNil(t, err)
+}
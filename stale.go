@@ -0,0 +1,144 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// StaleEventName is the name of the event dispatched when the resolver starts serving
+// the last known good instances of a service because etcd is unreachable.
+const StaleEventName = "etcd_resolver_stale"
+
+// StaleTagKey is the tag key set to "true" on the stale instances served by the resolver.
+const StaleTagKey = "etcd_stale"
+
+// staleCache keeps the last known good instances of each service,
+// which are served when reading etcd fails.
+type staleCache struct {
+	maxStaleness time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*staleEntry
+}
+
+type staleEntry struct {
+	instances []discovery.Instance
+	updatedAt time.Time
+	stale     bool
+	// the instances tagged as stale, built when they are first served
+	staleInstances []discovery.Instance
+}
+
+func newStaleCache(enabled bool, maxStaleness time.Duration) *staleCache {
+	if !enabled {
+		return nil
+	}
+	return &staleCache{
+		maxStaleness: maxStaleness,
+		entries:      make(map[string]*staleEntry),
+	}
+}
+
+// update records the fresh instances of the service.
+func (c *staleCache) update(desc string, eps []discovery.Instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(eps) == 0 {
+		delete(c.entries, desc)
+		return
+	}
+	c.entries[desc] = &staleEntry{
+		instances: eps,
+		updatedAt: time.Now(),
+	}
+}
+
+// serveStale returns the last known good instances of the service if they are not too old.
+func (e *etcdResolver) serveStale(desc string, cause error) ([]discovery.Instance, bool) {
+	c := e.staleCache
+	c.mu.Lock()
+	entry, ok := c.entries[desc]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	staleness := time.Since(entry.updatedAt)
+	if c.maxStaleness > 0 && staleness > c.maxStaleness {
+		delete(c.entries, desc)
+		c.mu.Unlock()
+		klog.Warnf("etcd resolver drops stale instances of %s, stale for %v", desc, staleness)
+		return nil, false
+	}
+	becomeStale := !entry.stale
+	entry.stale = true
+	if entry.staleInstances == nil {
+		entry.staleInstances = markStale(entry.instances)
+	}
+	eps := entry.staleInstances
+	c.mu.Unlock()
+
+	klog.Warnf("etcd resolver serves stale instances of %s, stale for %v, err: %v", desc, staleness, cause)
+	if becomeStale {
+		e.dispatchEvent(StaleEventName, fmt.Sprintf("serve stale instances of %s, err: %v", desc, cause))
+	}
+	return eps, true
+}
+
+// markStale returns copies of the instances tagged as stale.
+func markStale(eps []discovery.Instance) []discovery.Instance {
+	res := make([]discovery.Instance, 0, len(eps))
+	for _, ins := range eps {
+		info := toInstanceInfo(ins)
+		tags := make(map[string]string, len(info.Tags)+1)
+		for k, v := range info.Tags {
+			tags[k] = v
+		}
+		tags[StaleTagKey] = "true"
+		res = append(res, discovery.NewInstance(info.Network, info.Address, info.Weight, tags))
+	}
+	return res
+}
+
+// staleness returns how long each service being served stale has been stale.
+func (c *staleCache) staleness() map[string]time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[string]time.Duration)
+	for desc, entry := range c.entries {
+		if entry.stale {
+			res[desc] = time.Since(entry.updatedAt)
+		}
+	}
+	return res
+}
+
+// GetStaleness returns the services whose stale instances are being served by the resolver,
+// and how long they have been stale.
+func GetStaleness(r discovery.Resolver) map[string]time.Duration {
+	er, ok := r.(*etcdResolver)
+	if !ok {
+		panic("invalid resolver type: not etcdResolver")
+	}
+	if er.staleCache == nil {
+		return nil
+	}
+	return er.staleCache.staleness()
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/registry"
+	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEtcdResolverStaleFallback(t *testing.T) {
+	s, endpoint := setupEmbedEtcd(t)
+
+	rs, err := NewEtcdResolver([]string{endpoint}, WithStaleFallback(time.Hour))
+	require.Nil(t, err)
+	rsNoStale, err := NewEtcdResolver([]string{endpoint})
+	require.Nil(t, err)
+
+	info := registry.Info{
+		ServiceName: serviceName,
+		Addr:        utils.NewNetAddr("tcp", "127.0.0.1:8888"),
+		Weight:      10,
+	}
+	putInstance(t, rs.(*etcdResolver).etcdClient, &info)
+	result, err := rs.Resolve(context.TODO(), serviceName)
+	require.Nil(t, err)
+	require.Len(t, result.Instances, 1)
+	require.Empty(t, GetStaleness(rs))
+
+	// etcd becomes unreachable
+	teardownEmbedEtcd(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = rsNoStale.Resolve(ctx, serviceName)
+	require.NotNil(t, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	staleResult, err := rs.Resolve(ctx, serviceName)
+	require.Nil(t, err)
+	require.Equal(t, result.CacheKey, staleResult.CacheKey)
+	require.Equal(t, []discovery.Instance{
+		discovery.NewInstance("tcp", "127.0.0.1:8888", 10, map[string]string{StaleTagKey: "true"}),
+	}, staleResult.Instances)
+	_, ok := result.Instances[0].Tag(StaleTagKey)
+	require.False(t, ok)
+	require.Contains(t, GetStaleness(rs), serviceName)
+
+	// the stale instances are too old
+	rs.(*etcdResolver).staleCache.maxStaleness = time.Nanosecond
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = rs.Resolve(ctx, serviceName)
+	require.NotNil(t, err)
+	require.Empty(t, GetStaleness(rs))
+}
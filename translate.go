@@ -0,0 +1,187 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// translationTable maps registered addresses to reachable addresses.
+// A registered address is matched by "host:port", then by host, then by the longest CIDR containing the host.
+// If a reachable address has no port, the registered port is kept.
+type translationTable struct {
+	addrs map[string]string
+	hosts map[string]string
+	cidrs []cidrRule
+}
+
+type cidrRule struct {
+	network *net.IPNet
+	to      string
+}
+
+func newTranslationTable(table map[string]string) *translationTable {
+	t := &translationTable{
+		addrs: make(map[string]string),
+		hosts: make(map[string]string),
+	}
+	for from, to := range table {
+		if _, network, err := net.ParseCIDR(from); err == nil {
+			t.cidrs = append(t.cidrs, cidrRule{network: network, to: to})
+			continue
+		}
+		if _, _, err := net.SplitHostPort(from); err == nil {
+			t.addrs[from] = to
+			continue
+		}
+		t.hosts[from] = to
+	}
+	// the most specific network first
+	sort.Slice(t.cidrs, func(i, j int) bool {
+		oi, _ := t.cidrs[i].network.Mask.Size()
+		oj, _ := t.cidrs[j].network.Mask.Size()
+		return oi > oj
+	})
+	return t
+}
+
+func (t *translationTable) translate(addr string) string {
+	if to, ok := t.addrs[addr]; ok {
+		return to
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	to, ok := t.hosts[host]
+	if !ok {
+		if ip := net.ParseIP(host); ip != nil {
+			for _, rule := range t.cidrs {
+				if rule.network.Contains(ip) {
+					to, ok = rule.to, true
+					break
+				}
+			}
+		}
+	}
+	if !ok {
+		return addr
+	}
+	if _, _, err = net.SplitHostPort(to); err == nil {
+		return to
+	}
+	return net.JoinHostPort(to, port)
+}
+
+// addressTranslator merges the table watched from etcd over the table of options.
+type addressTranslator struct {
+	static map[string]string
+	key    string
+	table  atomic.Pointer[translationTable]
+}
+
+func newAddressTranslator(static map[string]string, key string) *addressTranslator {
+	if len(static) == 0 && key == "" {
+		return nil
+	}
+	t := &addressTranslator{static: static, key: key}
+	t.update(nil)
+	return t
+}
+
+func (t *addressTranslator) update(watched map[string]string) {
+	table := make(map[string]string, len(t.static)+len(watched))
+	for k, v := range t.static {
+		table[k] = v
+	}
+	for k, v := range watched {
+		table[k] = v
+	}
+	t.table.Store(newTranslationTable(table))
+}
+
+func (t *addressTranslator) apply(infos []instanceInfo) []instanceInfo {
+	table := t.table.Load()
+	for i := range infos {
+		infos[i].Address = table.translate(infos[i].Address)
+	}
+	return infos
+}
+
+// runTranslationWatch keeps the translation table up to date with the JSON map stored at the key until ctx is done.
+func (e *etcdResolver) runTranslationWatch(ctx context.Context) {
+	t := e.translator
+	for {
+		if err := e.syncTranslation(ctx); err != nil && ctx.Err() == nil {
+			klog.Warnf("etcd resolver watch address translation %s failed with err: %v", t.key, err)
+		}
+		select {
+		case <-ctx.Done():
+			klog.Infof("stop etcd resolver watch address translation %s", t.key)
+			return
+		case <-time.After(watchResyncDelay):
+		}
+	}
+}
+
+func (e *etcdResolver) syncTranslation(ctx context.Context) error {
+	t := e.translator
+	resp, err := e.etcdClient.Get(ctx, t.key)
+	if err != nil {
+		return err
+	}
+	var value []byte
+	if len(resp.Kvs) > 0 {
+		value = resp.Kvs[0].Value
+	}
+	e.updateTranslation(value)
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := e.etcdClient.Watch(clientv3.WithRequireLeader(wctx), t.key, clientv3.WithRev(resp.Header.Revision+1))
+	for resp := range wch {
+		if err = resp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range resp.Events {
+			if ev.Type == clientv3.EventTypeDelete {
+				e.updateTranslation(nil)
+				continue
+			}
+			e.updateTranslation(ev.Kv.Value)
+		}
+	}
+	return ctx.Err()
+}
+
+// updateTranslation replaces the watched table, an invalid value keeps the current table.
+func (e *etcdResolver) updateTranslation(value []byte) {
+	var watched map[string]string
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, &watched); err != nil {
+			klog.Warnf("fail to unmarshal address translation %s with err: %v", e.translator.key, err)
+			return
+		}
+	}
+	e.translator.update(watched)
+}
@@ -0,0 +1,36 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslationTable(t *testing.T) {
+	table := newTranslationTable(map[string]string{
+		"10.0.0.1:8888": "1.2.3.4:30001",
+		"10.0.0.2":      "1.2.3.5",
+		"10.0.0.0/8":    "lb.example.com:443",
+		"10.1.0.0/16":   "1.2.3.6",
+	})
+	require.Equal(t, "1.2.3.4:30001", table.translate("10.0.0.1:8888"))
+	require.Equal(t, "1.2.3.5:9999", table.translate("10.0.0.2:9999"))
+	require.Equal(t, "1.2.3.6:8888", table.translate("10.1.2.3:8888"))
+	require.Equal(t, "lb.example.com:443", table.translate("10.2.0.1:8888"))
+	require.Equal(t, "192.168.0.1:8888", table.translate("192.168.0.1:8888"))
+	require.Equal(t, "invalid", table.translate("invalid"))
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	watchResyncDelay = time.Second
+	// watchProgressInterval is the interval of progress requests, which tell a quiet watch from a broken one
+	watchProgressInterval = 5 * time.Second
+	// watchMaxAge is how long the cache is used without hearing from etcd
+	watchMaxAge = 3 * watchProgressInterval
+)
+
+// watchCache keeps the keys of all services under the root prefix up to date with a single watch.
+type watchCache struct {
+	root string
+
+	mu       sync.RWMutex
+	synced   bool
+	revision int64
+	services map[string]map[string]*mvccpb.KeyValue
+	// the last time the cache heard from etcd
+	updatedAt time.Time
+}
+
+func newWatchCache(prefix string) *watchCache {
+	return &watchCache{
+		root:     prefix + "/",
+		services: make(map[string]map[string]*mvccpb.KeyValue),
+	}
+}
+
+// get returns the keys of the service sorted by key,
+// and false if the cache is not synced or has not heard from etcd for too long.
+func (c *watchCache) get(desc string) ([]*mvccpb.KeyValue, int64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.synced || time.Since(c.updatedAt) > watchMaxAge {
+		return nil, 0, false
+	}
+	kvs := make([]*mvccpb.KeyValue, 0, len(c.services[desc]))
+	for _, kv := range c.services[desc] {
+		kvs = append(kvs, kv)
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return string(kvs[i].Key) < string(kvs[j].Key)
+	})
+	return kvs, c.revision, true
+}
+
+// serviceOf returns the service segment of the key.
+func (c *watchCache) serviceOf(key []byte) (string, bool) {
+	desc, _, found := strings.Cut(strings.TrimPrefix(string(key), c.root), "/")
+	return desc, found && desc != ""
+}
+
+func (c *watchCache) reset(kvs []*mvccpb.KeyValue, rev int64) {
+	services := make(map[string]map[string]*mvccpb.KeyValue)
+	for _, kv := range kvs {
+		desc, ok := c.serviceOf(kv.Key)
+		if !ok {
+			continue
+		}
+		if services[desc] == nil {
+			services[desc] = make(map[string]*mvccpb.KeyValue)
+		}
+		services[desc][string(kv.Key)] = kv
+	}
+	c.mu.Lock()
+	c.services = services
+	c.revision = rev
+	c.synced = true
+	c.updatedAt = time.Now()
+	c.mu.Unlock()
+}
+
+// invalidate makes resolves read etcd directly until the cache is synced again.
+func (c *watchCache) invalidate() {
+	c.mu.Lock()
+	c.synced = false
+	c.mu.Unlock()
+}
+
+func (c *watchCache) apply(events []*clientv3.Event, rev int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ev := range events {
+		desc, ok := c.serviceOf(ev.Kv.Key)
+		if !ok {
+			continue
+		}
+		switch ev.Type {
+		case clientv3.EventTypePut:
+			if c.services[desc] == nil {
+				c.services[desc] = make(map[string]*mvccpb.KeyValue)
+			}
+			c.services[desc][string(ev.Kv.Key)] = ev.Kv
+		case clientv3.EventTypeDelete:
+			delete(c.services[desc], string(ev.Kv.Key))
+			if len(c.services[desc]) == 0 {
+				delete(c.services, desc)
+			}
+		}
+	}
+	c.revision = rev
+	c.updatedAt = time.Now()
+}
+
+// runSharedWatch syncs the watch cache with etcd until ctx is done.
+// It reads the whole root prefix, then watches it from the next revision,
+// and starts over if the watch fails, e.g. the revision has been compacted.
+func (e *etcdResolver) runSharedWatch(ctx context.Context) {
+	c := e.watchCache
+	for {
+		if err := e.syncSharedWatch(ctx); err != nil && ctx.Err() == nil {
+			klog.Warnf("etcd resolver shared watch on %s failed with err: %v", c.root, err)
+		}
+		c.invalidate()
+		select {
+		case <-ctx.Done():
+			klog.Infof("stop etcd resolver shared watch on %s", c.root)
+			return
+		case <-time.After(watchResyncDelay):
+		}
+	}
+}
+
+func (e *etcdResolver) syncSharedWatch(ctx context.Context) error {
+	c := e.watchCache
+	kvs, rev, err := e.read(ctx, c.root, true, false)
+	if err != nil {
+		return err
+	}
+	c.reset(kvs, rev)
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wctx = clientv3.WithRequireLeader(wctx)
+	wch := e.etcdClient.Watch(wctx, c.root, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	// the watch does not fail while the client reconnects, so progress is requested to keep the cache fresh
+	ticker := time.NewTicker(watchProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case resp, ok := <-wch:
+			if !ok {
+				return ctx.Err()
+			}
+			if err = resp.Err(); err != nil {
+				return err
+			}
+			e.observeRevision(resp.Header.Revision)
+			c.apply(resp.Events, resp.Header.Revision)
+		case <-ticker.C:
+			if err = e.etcdClient.RequestProgress(wctx); err != nil {
+				klog.Debugf("request progress of shared watch on %s failed with err: %v", c.root, err)
+			}
+		}
+	}
+}
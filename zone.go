@@ -0,0 +1,100 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import "os"
+
+const (
+	localZoneKey                 = "KITEX_ETCD_RESOLVER_LOCAL_ZONE"
+	defaultZoneTagKey            = "zone"
+	defaultZoneFallbackThreshold = 1
+)
+
+// zoneFilter prefers instances located in the same zone as the client.
+type zoneFilter struct {
+	localZone string
+	tagKey    string
+	threshold int
+}
+
+func newZoneFilter(cfg *Config) *zoneFilter {
+	if !cfg.ZoneAware {
+		return nil
+	}
+	zone := cfg.LocalZone
+	if zone == "" {
+		zone = os.Getenv(localZoneKey)
+	}
+	if zone == "" {
+		return nil
+	}
+	tagKey := cfg.ZoneTagKey
+	if tagKey == "" {
+		tagKey = defaultZoneTagKey
+	}
+	threshold := cfg.ZoneFallbackThreshold
+	if threshold <= 0 {
+		threshold = defaultZoneFallbackThreshold
+	}
+	return &zoneFilter{
+		localZone: zone,
+		tagKey:    tagKey,
+		threshold: threshold,
+	}
+}
+
+// apply returns the same-zone instances if there are enough of them.
+// Otherwise, same-zone instances are followed by instances in other zones, and the weights
+// of the latter are scaled so that they only take the traffic of the missing same-zone instances.
+func (z *zoneFilter) apply(infos []instanceInfo) []instanceInfo {
+	var local, remote []instanceInfo
+	for _, info := range infos {
+		if info.Tags[z.tagKey] == z.localZone {
+			local = append(local, info)
+		} else {
+			remote = append(remote, info)
+		}
+	}
+	if len(local) >= z.threshold || len(remote) == 0 {
+		return local
+	}
+	if len(local) == 0 {
+		return remote
+	}
+
+	var localWeight, remoteWeight int
+	for _, info := range local {
+		localWeight += info.Weight
+	}
+	for _, info := range remote {
+		remoteWeight += info.Weight
+	}
+	res := make([]instanceInfo, 0, len(infos))
+	res = append(res, local...)
+	if localWeight <= 0 || remoteWeight <= 0 {
+		return append(res, remote...)
+	}
+	// remote instances share the weight of the missing same-zone instances
+	target := localWeight * (z.threshold - len(local)) / len(local)
+	for _, info := range remote {
+		weight := info.Weight * target / remoteWeight
+		if weight <= 0 {
+			weight = 1
+		}
+		info.Weight = weight
+		res = append(res, info)
+	}
+	return res
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 CloudWeGo Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneFilter(t *testing.T) {
+	require.Nil(t, newZoneFilter(&Config{}))
+	require.Nil(t, newZoneFilter(&Config{ZoneAware: true}))
+
+	t.Setenv(localZoneKey, "az1")
+	z := newZoneFilter(&Config{ZoneAware: true, ZoneFallbackThreshold: 3})
+	require.NotNil(t, z)
+	require.Equal(t, "az1", z.localZone)
+	require.Equal(t, defaultZoneTagKey, z.tagKey)
+
+	az1 := instanceInfo{Address: "127.0.0.1:8001", Weight: 10, Tags: map[string]string{"zone": "az1"}}
+	az2 := instanceInfo{Address: "127.0.0.1:8002", Weight: 10, Tags: map[string]string{"zone": "az2"}}
+	az3 := instanceInfo{Address: "127.0.0.1:8003", Weight: 30, Tags: map[string]string{"zone": "az3"}}
+
+	// enough same-zone instances
+	z.threshold = 1
+	require.Equal(t, []instanceInfo{az1}, z.apply([]instanceInfo{az2, az1, az3}))
+
+	// no same-zone instance
+	require.Equal(t, []instanceInfo{az2, az3}, z.apply([]instanceInfo{az2, az3}))
+
+	// fallback, other zones take the weight of the two missing same-zone instances
+	z.threshold = 3
+	res := z.apply([]instanceInfo{az2, az1, az3})
+	require.Len(t, res, 3)
+	require.Equal(t, az1, res[0])
+	require.Equal(t, az2.Address, res[1].Address)
+	require.Equal(t, 5, res[1].Weight)
+	require.Equal(t, az3.Address, res[2].Address)
+	require.Equal(t, 15, res[2].Weight)
+	require.Equal(t, 10, az2.Weight)
+}